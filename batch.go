@@ -0,0 +1,263 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Batch is the Data passed to the Task of a batching Stage.
+type Batch []Data
+
+// Clone implements the pipeline Data interface.
+func (b Batch) Clone() Data {
+	c := make(Batch, len(b))
+	for i, d := range b {
+		c[i] = d.Clone()
+	}
+	return c
+}
+
+// MarkAsProcessed implements the pipeline Data interface.
+func (b Batch) MarkAsProcessed() {
+	for _, d := range b {
+		d.MarkAsProcessed()
+	}
+}
+
+// Size implements the Sizer interface.
+func (b Batch) Size() int64 {
+	var size int64
+	for _, d := range b {
+		size += sizeOf(d)
+	}
+	return size
+}
+
+// MetricsReporter is implemented by Stages that expose runtime metrics.
+type MetricsReporter interface {
+	// Metrics returns the current value of each metric by name.
+	Metrics() map[string]float64
+}
+
+const (
+	maxAdaptiveBatchSize = 4096
+	latencySamples       = 256
+)
+
+type batcher struct {
+	sync.Mutex
+	task     Task
+	size     int
+	wait     time.Duration
+	adaptive bool
+	target   time.Duration
+	latency  *latencyWindow
+	// processing is the time taken by the task for the last batch
+	processing time.Duration
+}
+
+// FixedBatch returns a Stage that groups incoming data into a Batch passed
+// to the Task once it holds size items or the first item has waited for the
+// wait duration. The output of the Task is emitted to the next Stage.
+func FixedBatch(task Task, size int, wait time.Duration) Stage {
+	if size <= 0 {
+		return nil
+	}
+
+	return &batcher{
+		task:    task,
+		size:    size,
+		wait:    wait,
+		latency: newLatencyWindow(latencySamples),
+	}
+}
+
+// AdaptiveBatch returns a Stage that groups incoming data into a Batch passed
+// to the Task, like FixedBatch, but adjusts the batch size and wait duration
+// at runtime. The latency of each item is measured from its arrival at the
+// stage until the Task has processed its batch. The batch size grows while
+// the p99 latency stays below the target, and the size and wait duration are
+// reduced once the target is exceeded. The Stage implements MetricsReporter
+// to expose the current parameters.
+func AdaptiveBatch(task Task, target time.Duration) Stage {
+	if target <= 0 {
+		return nil
+	}
+
+	return &batcher{
+		task:     task,
+		size:     1,
+		wait:     target / 4,
+		adaptive: true,
+		target:   target,
+		latency:  newLatencyWindow(latencySamples),
+	}
+}
+
+func (b *batcher) taskList() []Task { return []Task{b.task} }
+
+// Metrics implements the MetricsReporter interface.
+func (b *batcher) Metrics() map[string]float64 {
+	b.Lock()
+	defer b.Unlock()
+
+	return map[string]float64{
+		"batch_size":               float64(b.size),
+		"batch_wait_seconds":       b.wait.Seconds(),
+		"batch_processing_seconds": b.processing.Seconds(),
+		"latency_p99_seconds":      b.latency.percentile(0.99).Seconds(),
+	}
+}
+
+func (b *batcher) params() (int, time.Duration) {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.size, b.wait
+}
+
+// Run implements Stage.
+func (b *batcher) Run(ctx context.Context, sp StageParams) {
+	task := chainTask(sp, b.task)
+	var batch Batch
+	var arrivals []time.Time
+	var timeout <-chan time.Time
+	var held int64
+
+	flush := func() bool {
+		if len(batch) == 0 {
+			return true
+		}
+
+		ok := b.process(ctx, sp, task, batch, arrivals, held)
+		batch, arrivals, timeout, held = nil, nil, nil, 0
+		return ok
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timeout:
+			if !flush() {
+				return
+			}
+		case data, ok := <-sp.Input():
+			if !ok {
+				flush()
+				return
+			}
+
+			if c, ok := data.(control); ok {
+				// The pending batch precedes the control record
+				if !flush() || !punctuate(ctx, sp, task, c) || !forwardControl(ctx, sp, c) {
+					return
+				}
+				continue
+			}
+
+			// An item cancelled on its own is discarded before joining the batch
+			if itemCancelled(ctx, itemsOf(sp).context(ctx, data)) {
+				data.MarkAsProcessed()
+				memoryOf(sp).drop(sp.Position(), memoryOf(sp).hold(sp.Position(), data))
+				discard(sp, sp.Position(), data, DropCancelled)
+				continue
+			}
+
+			size, wait := b.params()
+			held += memoryOf(sp).hold(sp.Position(), data)
+			batch = append(batch, data)
+			arrivals = append(arrivals, time.Now())
+			if len(batch) == 1 {
+				timeout = time.After(wait)
+			}
+			if len(batch) >= size && !flush() {
+				return
+			}
+		}
+	}
+}
+
+// process passes the batch holding size bytes to the task and emits the
+// output. It returns false if the task failed or the context expired.
+func (b *batcher) process(ctx context.Context, sp StageParams, task Task, batch Batch, arrivals []time.Time, size int64) bool {
+	start := time.Now()
+	dataOut, err := task.Process(ctx, batch)
+	reason, ok := dropReason(ctx, ctx, dataOut, err)
+	if !ok {
+		sp.Error().Append(stageError(sp, sp.Position(), err))
+		return false
+	}
+	// The items of the batch members end with the batch
+	for _, data := range batch {
+		if reason != "" {
+			itemsOf(sp).discard(data, reason)
+		} else {
+			itemsOf(sp).merge(data)
+		}
+	}
+
+	now := time.Now()
+	for _, arrival := range arrivals {
+		b.latency.add(now.Sub(arrival))
+	}
+	b.adjust(now.Sub(start))
+
+	// If the task did not output data for the
+	// next stage there is nothing we need to do
+	if reason != "" {
+		batch.MarkAsProcessed()
+		memoryOf(sp).drop(sp.Position(), size)
+		dropsOf(sp).record(sp, sp.Position(), batch, reason)
+		return true
+	}
+	memoryOf(sp).emit(sp.Position(), size, dataOut)
+	// Output processed data
+	select {
+	case <-ctx.Done():
+		return false
+	case sp.Output() <- dataOut:
+	}
+	return true
+}
+
+// adjust updates the batch parameters from the measured latencies.
+func (b *batcher) adjust(processing time.Duration) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.processing = processing
+	if !b.adaptive {
+		return
+	}
+
+	// Time spent waiting for the batch to fill is limited
+	// to what remains of the target after processing
+	maxWait := b.target - processing
+	if maxWait < 0 {
+		maxWait = 0
+	}
+
+	if p99 := b.latency.percentile(0.99); p99 > b.target {
+		// Back off quickly when the target is exceeded
+		b.size -= b.size / 4
+		if b.size < 1 {
+			b.size = 1
+		}
+		b.wait /= 2
+	} else if p99 < b.target*4/5 {
+		// Grow slowly while there is room below the target
+		step := b.size / 8
+		if step < 1 {
+			step = 1
+		}
+		if b.size += step; b.size > maxAdaptiveBatchSize {
+			b.size = maxAdaptiveBatchSize
+		}
+		b.wait += b.target / 20
+	}
+	if b.wait > maxWait {
+		b.wait = maxWait
+	}
+}
@@ -0,0 +1,89 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFixedBatch(t *testing.T) {
+	var sizes []int
+	task := TaskFunc(func(_ context.Context, d Data) (Data, error) {
+		sizes = append(sizes, len(d.(Batch)))
+		return d, nil
+	})
+
+	src := &sourceStub{data: stringDataValues(10)}
+	sink := new(sinkStub)
+
+	p := NewPipeline(FixedBatch(task, 3, time.Minute))
+	if err := p.Execute(context.TODO(), src, sink); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+	if want := []int{3, 3, 3, 1}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("Batch sizes do not match.\nWanted:%v\nGot:%v\n", want, sizes)
+	}
+
+	var got []Data
+	for _, d := range sink.data {
+		got = append(got, d.(Batch)...)
+	}
+	if !reflect.DeepEqual(got, src.data) {
+		t.Errorf("Data does not match.\nWanted:%v\nGot:%v\n", src.data, got)
+	}
+	assertAllProcessed(t, src.data)
+}
+
+func TestAdaptiveBatchGrows(t *testing.T) {
+	var lock sync.Mutex
+	var largest int
+	task := TaskFunc(func(_ context.Context, d Data) (Data, error) {
+		lock.Lock()
+		if n := len(d.(Batch)); n > largest {
+			largest = n
+		}
+		lock.Unlock()
+		return nil, nil
+	})
+
+	src := &sourceStub{data: stringDataValues(2000)}
+	stage := AdaptiveBatch(task, 50*time.Millisecond)
+
+	p := NewPipeline(stage)
+	if err := p.ExecuteBuffered(context.TODO(), src, new(sinkStub), 100); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+	if largest <= 1 {
+		t.Errorf("Expected the batch size to grow, got a largest batch of %d", largest)
+	}
+
+	metrics := stage.(MetricsReporter).Metrics()
+	for _, name := range []string{"batch_size", "batch_wait_seconds", "batch_processing_seconds", "latency_p99_seconds"} {
+		if _, ok := metrics[name]; !ok {
+			t.Errorf("Expected the %s metric to be reported", name)
+		}
+	}
+	assertAllProcessed(t, src.data)
+}
+
+func TestAdaptiveBatchBacksOff(t *testing.T) {
+	task := TaskFunc(func(_ context.Context, d Data) (Data, error) {
+		time.Sleep(5 * time.Millisecond)
+		return nil, nil
+	})
+
+	src := &sourceStub{data: stringDataValues(20)}
+	stage := AdaptiveBatch(task, time.Millisecond)
+
+	p := NewPipeline(stage)
+	if err := p.Execute(context.TODO(), src, new(sinkStub)); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+
+	metrics := stage.(MetricsReporter).Metrics()
+	if metrics["batch_size"] != 1 || metrics["batch_wait_seconds"] != 0 {
+		t.Errorf("Expected the batch parameters to back off when the target is exceeded: %v", metrics)
+	}
+}
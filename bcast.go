@@ -24,11 +24,21 @@ func Broadcast(tasks ...Task) Stage {
 	return &broadcast{fifos: fifos}
 }
 
+func (b *broadcast) taskList() []Task {
+	tasks := make([]Task, len(b.fifos))
+	for i, f := range b.fifos {
+		tasks[i] = f.(fifo).task
+	}
+	return tasks
+}
+
 // Run implements Stage.
 func (b *broadcast) Run(ctx context.Context, sp StageParams) {
 	var wg sync.WaitGroup
 	var inCh = make([]chan Data, len(b.fifos))
 
+	// Each FIFO reports when a control record has reached it
+	var fences sync.WaitGroup
 	// Start each FIFO in a goroutine. Each FIFO gets its own dedicated
 	// input channel and the shared output channel passed to Run.
 	for i := 0; i < len(b.fifos); i++ {
@@ -36,10 +46,16 @@ func (b *broadcast) Run(ctx context.Context, sp StageParams) {
 		inCh[i] = make(chan Data)
 		go func(fifoIndex int) {
 			fifoParams := &params{
-				stage:    sp.Position(),
-				inCh:     inCh[fifoIndex],
-				outCh:    sp.Output(),
-				errQueue: sp.Error(),
+				stage:      sp.Position(),
+				inCh:       inCh[fifoIndex],
+				outCh:      sp.Output(),
+				errQueue:   sp.Error(),
+				memory:     memoryOf(sp),
+				items:      itemsOf(sp),
+				drops:      dropsOf(sp),
+				names:      namesOf(sp),
+				middleware: middlewareOf(sp),
+				fence:      fences.Done,
 			}
 			b.fifos[fifoIndex].Run(ctx, fifoParams)
 			wg.Done()
@@ -56,6 +72,23 @@ loop:
 				break loop
 			}
 
+			if c, ok := data.(control); ok {
+				// Forward the record once all FIFOs have emitted the previous data
+				fences.Add(len(b.fifos))
+				for i := 0; i < len(b.fifos); i++ {
+					select {
+					case <-ctx.Done():
+						break loop
+					case inCh[i] <- c:
+					}
+				}
+				fences.Wait()
+				if !forwardControl(ctx, sp, c) {
+					break loop
+				}
+				continue
+			}
+
 			for i := len(b.fifos) - 1; i >= 0; i-- {
 				// As each FIFO might modify the data, to
 				// avoid data races we need to make a copy
@@ -63,6 +96,8 @@ loop:
 				var fifoData = data
 				if i != 0 {
 					fifoData = data.Clone()
+					memoryOf(sp).charge(fifoData)
+					itemsOf(sp).share(data, fifoData)
 				}
 				select {
 				case <-ctx.Done():
@@ -0,0 +1,132 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrBudgetExceeded is matched by the error returned when the tolerated
+// errors observed during a pipeline execution exceed the ErrorBudget.
+var ErrBudgetExceeded = errors.New("error budget exceeded")
+
+// Budget describes how many tolerated errors, such as skipped records,
+// a pipeline execution accepts before it is aborted. Each limit set to
+// zero is disabled.
+type Budget struct {
+	// Count is the maximum number of errors for the entire execution.
+	Count int
+	// Ratio is the maximum ratio of errors to records read from the InputSource.
+	Ratio float64
+	// MinRecords is the number of records that must be read before Ratio is enforced.
+	MinRecords int
+	// Rate is the maximum number of errors observed within the sliding Window.
+	Rate   int
+	Window time.Duration
+	// Samples is the number of errors included in the BudgetError. Defaults to 5.
+	Samples int
+}
+
+// BudgetError is returned when the ErrorBudget has been exceeded.
+type BudgetError struct {
+	// Reason describes the limit that was exceeded.
+	Reason string
+	// Total is the number of errors observed during the execution.
+	Total int
+	// Sample holds the first errors observed during the execution.
+	Sample []error
+}
+
+// Error implements the error interface.
+func (e *BudgetError) Error() string {
+	msgs := make([]string, len(e.Sample))
+	for i, err := range e.Sample {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%v: %s; sample of %d errors: [%s]", ErrBudgetExceeded, e.Reason, e.Total, strings.Join(msgs, "; "))
+}
+
+// Is reports whether target is ErrBudgetExceeded.
+func (e *BudgetError) Is(target error) bool { return target == ErrBudgetExceeded }
+
+// ErrorBudget returns an Option that aborts the pipeline execution
+// once the tolerated errors exceed the provided Budget.
+func ErrorBudget(b Budget) Option {
+	return func(p *Pipeline) {
+		if b.Samples <= 0 {
+			b.Samples = 5
+		}
+		p.budget = &b
+	}
+}
+
+type budgetTracker struct {
+	sync.Mutex
+	budget  *Budget
+	records int
+	total   int
+	sample  []error
+	times   []time.Time
+}
+
+func newBudgetTracker(b *Budget) *budgetTracker {
+	if b == nil {
+		return nil
+	}
+	return &budgetTracker{budget: b}
+}
+
+// record counts a record read from the InputSource.
+func (t *budgetTracker) record() {
+	if t == nil {
+		return
+	}
+
+	t.Lock()
+	t.records++
+	t.Unlock()
+}
+
+// tolerate counts the error and returns a BudgetError once the budget has been exceeded.
+func (t *budgetTracker) tolerate(err error) error {
+	if t == nil {
+		return nil
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	t.total++
+	if len(t.sample) < t.budget.Samples {
+		t.sample = append(t.sample, err)
+	}
+
+	var reason string
+	b := t.budget
+	if b.Count > 0 && t.total > b.Count {
+		reason = fmt.Sprintf("%d errors exceed the limit of %d", t.total, b.Count)
+	} else if b.Ratio > 0 && t.records > 0 && t.records >= b.MinRecords &&
+		float64(t.total)/float64(t.records) > b.Ratio {
+		reason = fmt.Sprintf("%d errors in %d records exceed the ratio of %.2f", t.total, t.records, b.Ratio)
+	} else if b.Rate > 0 && b.Window > 0 {
+		now := time.Now()
+		t.times = append(t.times, now)
+		for len(t.times) > 0 && now.Sub(t.times[0]) > b.Window {
+			t.times = t.times[1:]
+		}
+		if len(t.times) > b.Rate {
+			reason = fmt.Sprintf("%d errors within %v exceed the rate of %d", len(t.times), b.Window, b.Rate)
+		}
+	}
+
+	if reason == "" {
+		return nil
+	}
+	return fmt.Errorf("pipeline: %w", &BudgetError{
+		Reason: reason,
+		Total:  t.total,
+		Sample: append([]error(nil), t.sample...),
+	})
+}
@@ -0,0 +1,61 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestErrorBudgetCount(t *testing.T) {
+	src := &sourceStub{data: makeSkipValues(10, 2)}
+	sink := new(sinkStub)
+
+	p := NewPipeline(FIFO(makePassthroughTask())).With(ErrorBudget(Budget{Count: 3, Samples: 2}))
+	err := p.Execute(context.TODO(), src, sink)
+	if !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("Error did not match the expectation: %v", err)
+	}
+
+	var berr *BudgetError
+	if !errors.As(err, &berr) || berr.Total != 4 || len(berr.Sample) != 2 {
+		t.Errorf("Unexpected budget error: %v", err)
+	}
+}
+
+func TestErrorBudgetRatio(t *testing.T) {
+	src := &sourceStub{data: makeSkipValues(20, 4)}
+	sink := new(sinkStub)
+
+	// One in four records is skipped, which stays within the ratio
+	p := NewPipeline(FIFO(makePassthroughTask())).With(ErrorBudget(Budget{Ratio: 0.3, MinRecords: 4}))
+	if err := p.Execute(context.TODO(), src, sink); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+
+	src = &sourceStub{data: makeSkipValues(20, 2)}
+	p = NewPipeline(FIFO(makePassthroughTask())).With(ErrorBudget(Budget{Ratio: 0.3, MinRecords: 4}))
+	if err := p.Execute(context.TODO(), src, sink); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("Error did not match the expectation: %v", err)
+	}
+}
+
+func TestErrorBudgetRate(t *testing.T) {
+	src := &sourceStub{data: makeSkipValues(10, 1)}
+	sink := new(sinkStub)
+
+	p := NewPipeline(FIFO(makePassthroughTask())).With(ErrorBudget(Budget{Rate: 5, Window: time.Minute}))
+	if err := p.Execute(context.TODO(), src, sink); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("Error did not match the expectation: %v", err)
+	}
+}
+
+// makeSkipValues returns num records where every nth record is skipped.
+func makeSkipValues(num, nth int) []Data {
+	data := stringDataValues(num)
+	for i := nth - 1; i < num; i += nth {
+		data[i] = Skip(data[i], fmt.Errorf("malformed record %d", i))
+	}
+	return data
+}
@@ -0,0 +1,229 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Checkpoint is a consistent snapshot of the InputSource offset and the keyed
+// state of each stage, taken once all the Data read from the InputSource
+// before the offset had been processed by the entire pipeline.
+type Checkpoint struct {
+	ID     uint64
+	Offset []byte
+	State  map[string]StateEntry
+}
+
+// CheckpointStore persists the completed checkpoints.
+type CheckpointStore interface {
+	// Save durably stores the checkpoint.
+	Save(*Checkpoint) error
+
+	// Load returns the latest checkpoint, or nil if none has been stored.
+	Load() (*Checkpoint, error)
+}
+
+// CheckpointSource is implemented by InputSources that can resume reading
+// from the offset stored in a checkpoint.
+type CheckpointSource interface {
+	InputSource
+
+	// Offset returns the position following the last Data returned by the source.
+	Offset() ([]byte, error)
+
+	// Seek moves the source to the provided offset.
+	Seek([]byte) error
+}
+
+// FileCheckpointStore is a CheckpointStore that keeps the latest checkpoint in a
+// file. State values are encoded with encoding/gob, so the concrete types stored
+// in the keyed state must be registered with gob.
+type FileCheckpointStore struct {
+	path string
+}
+
+// NewFileCheckpointStore returns a FileCheckpointStore that writes the checkpoint to path.
+func NewFileCheckpointStore(path string) *FileCheckpointStore {
+	return &FileCheckpointStore{path: path}
+}
+
+// Save implements the CheckpointStore interface.
+func (f *FileCheckpointStore) Save(cp *Checkpoint) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := gob.NewEncoder(tmp).Encode(cp); err != nil {
+		tmp.Close()
+		return err
+	}
+	// Make sure the checkpoint is durable before it replaces the previous one
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+// Load implements the CheckpointStore interface.
+func (f *FileCheckpointStore) Load() (*Checkpoint, error) {
+	file, err := os.Open(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	cp := new(Checkpoint)
+	if err := gob.NewDecoder(file).Decode(cp); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// Checkpointing returns an Option that has the pipeline take a checkpoint at
+// the provided interval and once the InputSource has been exhausted. Barriers
+// injected after the InputSource data flow through each stage, which snapshots
+// its keyed state when the barrier arrives, and the checkpoint is saved to the
+// store when the barrier reaches the OutputSink. Executions resume from the
+// latest checkpoint in the store, in which case the keyed state is restored from
+// the checkpoint instead of a Snapshotter backend. Stage implementations outside
+// of this package must forward the Data they do not recognize for the barriers
+// to complete.
+func Checkpointing(store CheckpointStore, interval time.Duration) Option {
+	return func(p *Pipeline) {
+		p.checkpoints = store
+		p.checkpointInterval = interval
+	}
+}
+
+// barrier is the control record that marks the point in the flow of
+// Data where a checkpoint is taken.
+type barrier struct {
+	sync.Mutex
+	cp    *Checkpoint
+	final bool
+	store *stateStore
+	save  func(context.Context, *Checkpoint, bool) error
+}
+
+// Clone implements the pipeline Data interface.
+func (b *barrier) Clone() Data { return b }
+
+// MarkAsProcessed implements the pipeline Data interface.
+func (b *barrier) MarkAsProcessed() {}
+
+func (b *barrier) atStage(_ context.Context, position int) {
+	if b.store == nil {
+		return
+	}
+
+	prefix := strconv.Itoa(position) + "/"
+	now := time.Now()
+	b.Lock()
+	defer b.Unlock()
+
+	b.store.backend.Range(func(key string, entry StateEntry) bool {
+		if strings.HasPrefix(key, prefix) && !entry.expired(now) {
+			b.cp.State[key] = entry
+		}
+		return true
+	})
+}
+
+func (b *barrier) atSink(ctx context.Context) error {
+	return b.save(ctx, b.cp, b.final)
+}
+
+// checkpointer injects the barriers for an execution and saves the completed checkpoints.
+type checkpointer struct {
+	store    CheckpointStore
+	state    *stateStore
+	interval time.Duration
+	nextID   uint64
+	last     time.Time
+}
+
+// restoreCheckpoint resumes the execution from the latest checkpoint in the store.
+func (e *execution) restoreCheckpoint(src InputSource) (*Checkpoint, error) {
+	cp, err := e.checkpoints.Load()
+	if err != nil || cp == nil {
+		return nil, err
+	}
+
+	if cs, ok := src.(CheckpointSource); ok && cp.Offset != nil {
+		if err := cs.Seek(cp.Offset); err != nil {
+			return nil, err
+		}
+	}
+	if e.state != nil {
+		var keys []string
+		e.state.backend.Range(func(key string, _ StateEntry) bool {
+			keys = append(keys, key)
+			return true
+		})
+		for _, key := range keys {
+			e.state.backend.Delete(key)
+		}
+		for key, entry := range cp.State {
+			e.state.backend.Set(key, entry)
+		}
+	}
+
+	e.checkpointer.nextID = cp.ID + 1
+	return cp, nil
+}
+
+// nextBarrier returns a new barrier for the next checkpoint if one is due.
+func (e *execution) nextBarrier(src InputSource, final bool) (*barrier, error) {
+	c := e.checkpointer
+	if c == nil || (!final && (c.interval <= 0 || time.Since(c.last) < c.interval)) {
+		return nil, nil
+	}
+
+	var offset []byte
+	if cs, ok := src.(CheckpointSource); ok {
+		var err error
+		if offset, err = cs.Offset(); err != nil {
+			return nil, err
+		}
+	}
+
+	c.last = time.Now()
+	b := &barrier{
+		cp: &Checkpoint{
+			ID:     c.nextID,
+			Offset: offset,
+			State:  make(map[string]StateEntry),
+		},
+		final: final,
+		store: c.state,
+		save:  e.saveCheckpoint,
+	}
+	c.nextID++
+	return b, nil
+}
+
+func (e *execution) saveCheckpoint(ctx context.Context, cp *Checkpoint, final bool) error {
+	if e.txnSink != nil {
+		return e.commitTxn(ctx, cp, final)
+	}
+	if err := e.checkpoints.Save(cp); err != nil {
+		return fmt.Errorf("pipeline checkpoint %d: %v", cp.ID, err)
+	}
+	return nil
+}
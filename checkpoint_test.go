@@ -0,0 +1,155 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCheckpointAlignment(t *testing.T) {
+	tests := []struct {
+		stages []Stage
+		opts   []Option
+	}{
+		{stages: []Stage{DynamicPool(makeTotalTask(true), 5)}},
+		{stages: []Stage{FixedPool(makeTotalTask(true), 5)}},
+		{stages: []Stage{Broadcast(makeTotalTask(true), makePassthroughTask())}},
+		{
+			stages: []Stage{FIFO(makePassthroughTask()), FIFO(makeTotalTask(true))},
+			opts:   []Option{FuseStages()},
+		},
+	}
+
+	for i, test := range tests {
+		store := new(checkpointStoreStub)
+		src := &checkpointSourceStub{sourceStub: sourceStub{data: stringDataValues(50)}}
+		sink := new(sinkStub)
+
+		p := NewPipeline(test.stages...).With(KeyedState(NewMemoryBackend(), 0), Checkpointing(store, time.Nanosecond))
+		if err := p.With(test.opts...).Execute(context.TODO(), src, sink); err != nil {
+			t.Errorf("Error executing the Pipeline: %v", err)
+		}
+		if len(store.saved) < 2 {
+			t.Fatalf("Expected multiple checkpoints for test %d, got %d", i, len(store.saved))
+		}
+
+		// The state of each checkpoint only covers the data before the offset
+		key := fmt.Sprintf("%d/total/all", len(test.stages))
+		for _, cp := range store.saved {
+			var total int
+			if entry, ok := cp.State[key]; ok {
+				total = entry.Value.(int)
+			}
+			if offset, _ := strconv.Atoi(string(cp.Offset)); total != offset {
+				t.Errorf("Checkpoint %d for test %d holds a total of %d at offset %d", cp.ID, i, total, offset)
+			}
+		}
+		if last := store.saved[len(store.saved)-1]; string(last.Offset) != "50" {
+			t.Errorf("Expected the final checkpoint at offset 50, got %s", last.Offset)
+		}
+	}
+}
+
+func TestCheckpointResume(t *testing.T) {
+	store := NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoint"))
+	backend := NewMemoryBackend()
+	data := stringDataValues(20)
+
+	failing := TaskFunc(func(_ context.Context, d Data) (Data, error) {
+		if d.(*stringData).val == "10" {
+			return nil, errors.New("task error")
+		}
+		return d, nil
+	})
+
+	src := &checkpointSourceStub{sourceStub: sourceStub{data: data}}
+	p := NewPipeline(FIFO(makeTotalTask(false)), FIFO(failing)).With(KeyedState(backend, 0), Checkpointing(store, time.Nanosecond))
+	if err := p.Execute(context.TODO(), src, new(sinkStub)); OutcomeOf(err) != Failed {
+		t.Fatalf("Expected the execution to fail, got %v", err)
+	}
+
+	// The failure can cancel the execution before the barrier
+	// for offset 10 reaches the sink
+	cp, err := store.Load()
+	if err != nil || cp == nil {
+		t.Fatalf("Expected a checkpoint from the failed execution: %v", err)
+	}
+	offset, _ := strconv.Atoi(string(cp.Offset))
+	if offset > 10 {
+		t.Fatalf("Expected a checkpoint at offset 10 or earlier, got %d", offset)
+	}
+
+	// Resume the execution from the latest checkpoint
+	src = &checkpointSourceStub{sourceStub: sourceStub{data: data}}
+	sink := new(sinkStub)
+	p = NewPipeline(FIFO(makeTotalTask(false)), FIFO(makePassthroughTask())).With(KeyedState(backend, 0), Checkpointing(store, time.Nanosecond))
+	if err := p.Execute(context.TODO(), src, sink); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+
+	if want := data[offset:]; !reflect.DeepEqual(sink.data, want) {
+		t.Errorf("Expected the resumed execution to start at offset %d, got %d data", offset, len(sink.data))
+	}
+	if entry, ok := backend.Get("1/total/all"); !ok || entry.Value != 20 {
+		t.Errorf("Expected each data to be counted once, got a total of %v", entry.Value)
+	}
+}
+
+// makeTotalTask returns a Task that counts the data in the keyed state.
+func makeTotalTask(delay bool) Task {
+	return TaskFunc(func(ctx context.Context, d Data) (Data, error) {
+		if delay {
+			time.Sleep(time.Duration(rand.Intn(100)) * time.Microsecond)
+		}
+
+		StateFromContext(ctx).Value("total", "all").Update(func(value interface{}, _ bool) interface{} {
+			total, _ := value.(int)
+			return total + 1
+		})
+		return d, nil
+	})
+}
+
+type checkpointSourceStub struct {
+	sourceStub
+}
+
+func (s *checkpointSourceStub) Offset() ([]byte, error) {
+	return []byte(strconv.Itoa(s.index)), nil
+}
+
+func (s *checkpointSourceStub) Seek(offset []byte) error {
+	index, err := strconv.Atoi(string(offset))
+	s.index = index
+	return err
+}
+
+type checkpointStoreStub struct {
+	sync.Mutex
+	saved []*Checkpoint
+}
+
+func (s *checkpointStoreStub) Save(cp *Checkpoint) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.saved = append(s.saved, cp)
+	return nil
+}
+
+func (s *checkpointStoreStub) Load() (*Checkpoint, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.saved) == 0 {
+		return nil, nil
+	}
+	return s.saved[len(s.saved)-1], nil
+}
@@ -0,0 +1,101 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+// control is implemented by the records that travel through the pipeline
+// channels next to the Data. Control records are never passed to a Task
+// or consumed by the OutputSink. Stage implementations outside of this
+// package receive them as regular Data and are expected to forward them.
+type control interface {
+	Data
+
+	// atStage is called once by each stage after all the Data received
+	// before the control record has been emitted to the next stage.
+	atStage(ctx context.Context, position int)
+
+	// atSink is called when the control record reaches the OutputSink.
+	atSink(ctx context.Context) error
+}
+
+// forwardControl handles the control record at the stage and sends it to
+// the next stage. It returns false if the context expired in the meantime.
+func forwardControl(ctx context.Context, sp StageParams, c control) bool {
+	// Stages running inside another stage only report the arrival
+	if p, ok := sp.(*params); ok && p.fence != nil {
+		p.fence()
+		return true
+	}
+
+	c.atStage(ctx, sp.Position())
+	select {
+	case <-ctx.Done():
+		return false
+	case sp.Output() <- c:
+	}
+	return true
+}
+
+// abandonControl is called by a stage that fails before forwarding the control
+// record. Stages running inside another stage still report the arrival, so the
+// outer stage is not left waiting for them.
+func abandonControl(sp StageParams) {
+	if p, ok := sp.(*params); ok && p.fence != nil {
+		p.fence()
+	}
+}
+
+// gate aligns the workers of a stage that share the same input channel,
+// so a control record is only forwarded once the Data received before
+// it has been emitted and before any Data received after it is processed.
+// A nil gate is used by stages with a single worker.
+type gate struct {
+	sync.Mutex
+	inflight sync.WaitGroup
+}
+
+// receive returns the next record from the input channel. After receiving
+// a control record, the caller must call release once it has been forwarded.
+// Otherwise, the caller must call done once the Data has been processed.
+func (g *gate) receive(ctx context.Context, in <-chan Data) (Data, bool) {
+	if g != nil {
+		g.Lock()
+	}
+
+	var data Data
+	var ok bool
+	select {
+	case <-ctx.Done():
+	case data, ok = <-in:
+	}
+	if g == nil {
+		return data, ok
+	}
+	if !ok {
+		g.Unlock()
+		return nil, false
+	}
+
+	if _, isCtl := data.(control); isCtl {
+		// Keep other workers from receiving until the record has been forwarded
+		g.inflight.Wait()
+		return data, true
+	}
+	g.inflight.Add(1)
+	g.Unlock()
+	return data, true
+}
+
+func (g *gate) release() {
+	if g != nil {
+		g.Unlock()
+	}
+}
+
+func (g *gate) done() {
+	if g != nil {
+		g.inflight.Done()
+	}
+}
@@ -0,0 +1,142 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// The drop reasons reported by the pipeline itself.
+const (
+	// DropUnspecified is the reason for the Data a Task discarded by returning nil.
+	DropUnspecified = "unspecified"
+	// DropCancelled is the reason for the Data of an item cancelled on its own.
+	DropCancelled = "cancelled"
+)
+
+// DropError is returned by a Task to discard the Data for a reason, without
+// failing the execution.
+type DropError struct {
+	Reason string
+}
+
+// Drop returns the error for a Task to discard the Data for the reason.
+func Drop(reason string) error {
+	return &DropError{Reason: reason}
+}
+
+// Error implements the error interface.
+func (e *DropError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrItemDropped, e.Reason)
+}
+
+// Is reports whether target is ErrItemDropped.
+func (e *DropError) Is(target error) bool { return target == ErrItemDropped }
+
+// DropEvent describes the Data discarded by a stage.
+type DropEvent struct {
+	// Position is the position of the stage in the pipeline.
+	Position int
+
+	// Name is the name of the stage within its StageGroup, if any.
+	Name string
+
+	// Reason is the reason provided by Drop, DropUnspecified or DropCancelled.
+	Reason string
+
+	// Data is the discarded Data.
+	Data Data
+}
+
+// OnDrop returns an Option that passes each Data discarded by a stage to the
+// observer. The observer is called by the goroutine of the stage and must not
+// block for long.
+func OnDrop(observer func(DropEvent)) Option {
+	return func(p *Pipeline) {
+		p.dropObserver = observer
+	}
+}
+
+type dropKey struct {
+	position int
+	reason   string
+}
+
+// dropTracker counts the Data discarded during an execution by stage and reason.
+type dropTracker struct {
+	sync.Mutex
+	observer func(DropEvent)
+	progress *progressTracker
+	counts   map[dropKey]int64
+}
+
+func newDropTracker(observer func(DropEvent), progress *progressTracker) *dropTracker {
+	return &dropTracker{
+		observer: observer,
+		progress: progress,
+		counts:   make(map[dropKey]int64),
+	}
+}
+
+// dropsOf returns the drop tracker available to the stage, if any.
+func dropsOf(sp StageParams) *dropTracker {
+	if p, ok := sp.(*params); ok {
+		return p.drops
+	}
+	return nil
+}
+
+func (d *dropTracker) record(sp StageParams, position int, data Data, reason string) {
+	if d == nil {
+		return
+	}
+
+	d.Lock()
+	d.counts[dropKey{position: position, reason: reason}]++
+	d.Unlock()
+	d.progress.addDropped()
+
+	if d.observer != nil {
+		d.observer(DropEvent{
+			Position: position,
+			Name:     stageName(sp, position),
+			Reason:   reason,
+			Data:     data,
+		})
+	}
+}
+
+func (d *dropTracker) metrics(metrics map[string]float64) {
+	d.Lock()
+	defer d.Unlock()
+
+	for key, count := range d.counts {
+		metrics[fmt.Sprintf("stage_%d_dropped_%s", key.position, key.reason)] = float64(count)
+	}
+}
+
+// dropReason returns the reason for discarding the Data processed with ictx, given
+// the output and the error of the Task, or an empty reason if the output is to be
+// emitted. It returns false if the error fails the stage.
+func dropReason(ctx, ictx context.Context, out Data, err error) (string, bool) {
+	var derr *DropError
+
+	switch {
+	case itemCancelled(ctx, ictx):
+		return DropCancelled, true
+	case errors.As(err, &derr):
+		return derr.Reason, true
+	case err != nil:
+		return "", false
+	case out == nil:
+		return DropUnspecified, true
+	}
+	return "", true
+}
+
+// discard reports the Data discarded by the stage at the position for the reason.
+func discard(sp StageParams, position int, data Data, reason string) {
+	dropsOf(sp).record(sp, position, data, reason)
+	itemsOf(sp).discard(data, reason)
+}
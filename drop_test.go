@@ -0,0 +1,105 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestDropReasons(t *testing.T) {
+	values := stringDataValues(6)
+	filter := TaskFunc(func(_ context.Context, d Data) (Data, error) {
+		switch d.(*stringData).val {
+		case "1", "3":
+			return nil, Drop("odd")
+		case "4":
+			return nil, nil
+		}
+		return d, nil
+	})
+
+	var lock sync.Mutex
+	var events []DropEvent
+	observer := func(ev DropEvent) {
+		lock.Lock()
+		events = append(events, ev)
+		lock.Unlock()
+	}
+
+	sink := new(sinkStub)
+	p := NewPipeline(FIFO(makePassthroughTask()), Group("filter", FIFO(filter))).With(OnDrop(observer))
+	if err := p.Execute(context.TODO(), &sourceStub{data: values}, sink); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+
+	if want := []Data{values[0], values[2], values[5]}; !reflect.DeepEqual(sink.data, want) {
+		t.Errorf("Data does not match.\nWanted:%v\nGot:%v\n", want, sink.data)
+	}
+	m := p.Metrics()
+	if m["stage_2_dropped_odd"] != 2 || m["stage_2_dropped_unspecified"] != 1 {
+		t.Errorf("Unexpected drop metrics: %v", m)
+	}
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 drop events, got %d", len(events))
+	}
+	if ev := events[0]; ev.Position != 2 || ev.Name != "filter/1" || ev.Reason != "odd" || ev.Data != values[1] {
+		t.Errorf("Unexpected drop event: %+v", ev)
+	}
+	assertAllProcessed(t, values)
+}
+
+func TestDropReasonsFused(t *testing.T) {
+	filter := TaskFunc(func(_ context.Context, d Data) (Data, error) {
+		return nil, Drop("filtered")
+	})
+	keep := TaskFunc(func(_ context.Context, d Data) (Data, error) {
+		if d.(*stringData).val == "0" {
+			return nil, Drop("parallel")
+		}
+		return d, nil
+	})
+
+	p := NewPipeline(Parallel(keep, makePassthroughTask()), FIFO(makePassthroughTask()), FIFO(filter)).With(FuseStages())
+	if err := p.Execute(context.TODO(), &sourceStub{data: stringDataValues(3)}, new(sinkStub)); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+
+	m := p.Metrics()
+	if m["stage_1_dropped_parallel"] != 1 || m["stage_3_dropped_filtered"] != 2 {
+		t.Errorf("Unexpected drop metrics: %v", m)
+	}
+}
+
+func TestParallelErrorNotDropped(t *testing.T) {
+	failing := TaskFunc(func(_ context.Context, d Data) (Data, error) {
+		return nil, errors.New("task error")
+	})
+
+	var dropped int
+	src := &sourceStub{data: stringDataValues(1)}
+	p := NewPipeline(Parallel(makePassthroughTask(), failing)).With(OnDrop(func(DropEvent) { dropped++ }))
+	if err := p.Execute(context.TODO(), src, new(sinkStub)); OutcomeOf(err) != Failed {
+		t.Errorf("Expected the execution to fail, got %v", err)
+	}
+
+	if dropped != 0 || p.Metrics()["stage_1_dropped_unspecified"] != 0 {
+		t.Errorf("Expected the failure not to be reported as a drop")
+	}
+	assertAllProcessed(t, src.data)
+}
+
+func TestFutureDropReason(t *testing.T) {
+	filter := TaskFunc(func(_ context.Context, d Data) (Data, error) {
+		return nil, Drop("rejected")
+	})
+	inj, s := NewPipeline(FIFO(filter)).Start(context.TODO(), 1, SubmitBlock)
+	defer s.Close()
+
+	_, err := inj.SubmitAndWait(context.TODO(), &stringData{val: "1"})
+	var derr *DropError
+	if !errors.As(err, &derr) || derr.Reason != "rejected" || !errors.Is(err, ErrItemDropped) {
+		t.Errorf("Expected the drop reason, got %v", err)
+	}
+}
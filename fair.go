@@ -0,0 +1,261 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// fairQueueFactor bounds the Data queued by a FairPool to this many per worker.
+const fairQueueFactor = 16
+
+type fairPool struct {
+	sync.Mutex
+	task    Task
+	workers int
+	tenant  func(Data) string
+	weights map[string]int
+	stats   map[string]*tenantStats
+}
+
+type tenantStats struct {
+	queued int
+	count  int64
+	waited time.Duration
+}
+
+// FairPool returns a Stage that spins up a pool containing workers to process
+// incoming data in parallel, like FixedPool, and shares the workers among the
+// tenants returned by the tenant function. Each tenant has its own queue, and
+// the queues are scheduled with deficit round robin according to the tenant
+// weights, which default to 1. The capacity not used by idle tenants is shared
+// by the others. The Stage implements MetricsReporter to expose the average
+// wait and the queued data of each tenant.
+func FairPool(task Task, workers int, tenant func(Data) string, weights map[string]int) Stage {
+	if workers <= 0 || tenant == nil {
+		return nil
+	}
+
+	return &fairPool{
+		task:    task,
+		workers: workers,
+		tenant:  tenant,
+		weights: weights,
+		stats:   make(map[string]*tenantStats),
+	}
+}
+
+func (p *fairPool) taskList() []Task { return []Task{p.task} }
+
+// Metrics implements the MetricsReporter interface.
+func (p *fairPool) Metrics() map[string]float64 {
+	p.Lock()
+	defer p.Unlock()
+
+	metrics := make(map[string]float64)
+	for name, s := range p.stats {
+		var wait time.Duration
+		if s.count > 0 {
+			wait = s.waited / time.Duration(s.count)
+		}
+
+		metrics[fmt.Sprintf("tenant_%s_wait_seconds", name)] = wait.Seconds()
+		metrics[fmt.Sprintf("tenant_%s_queued", name)] = float64(s.queued)
+	}
+	return metrics
+}
+
+// Run implements Stage.
+func (p *fairPool) Run(ctx context.Context, sp StageParams) {
+	var wg sync.WaitGroup
+	work := make(chan *fairItem)
+	// Each worker holds at most one item that has not been reported
+	finished := make(chan struct{}, p.workers)
+	task := chainTask(sp, p.task)
+
+	for i := 0; i < p.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for item := range work {
+				if !processFIFO(ctx, sp, task, item.data) {
+					return
+				}
+				finished <- struct{}{}
+			}
+		}()
+	}
+
+	p.dispatch(ctx, sp, task, work, finished)
+	close(work)
+	wg.Wait()
+}
+
+// dispatch queues the incoming data by tenant and sends the next item chosen
+// by the scheduler to a free worker. Control records wait for the queues to
+// be empty and the workers to finish.
+func (p *fairPool) dispatch(ctx context.Context, sp StageParams, task Task, work chan<- *fairItem, finished <-chan struct{}) {
+	q := &fairQueue{tenants: make(map[string]*fairTenant)}
+	in := sp.Input()
+
+	var next *fairItem
+	var pending control
+	var inflight int
+	for {
+		if next == nil {
+			next = q.next()
+		}
+		if next == nil && inflight == 0 {
+			if in == nil {
+				return
+			}
+			if pending != nil {
+				if !punctuate(ctx, sp, task, pending) || !forwardControl(ctx, sp, pending) {
+					return
+				}
+				pending = nil
+			}
+		}
+
+		var out chan<- *fairItem
+		if next != nil {
+			out = work
+		}
+		recv := in
+		if pending != nil || q.queued >= p.workers*fairQueueFactor {
+			recv = nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-finished:
+			inflight--
+		case out <- next:
+			inflight++
+			p.dispatched(next)
+			next = nil
+		case data, ok := <-recv:
+			if !ok {
+				in = nil
+				continue
+			}
+
+			if c, ok := data.(control); ok {
+				pending = c
+				continue
+			}
+
+			name := p.tenant(data)
+			q.push(name, p.weight(name), data)
+			p.queued(name)
+		}
+	}
+}
+
+func (p *fairPool) weight(name string) int {
+	if w := p.weights[name]; w > 0 {
+		return w
+	}
+	return 1
+}
+
+func (p *fairPool) queued(name string) {
+	p.Lock()
+	defer p.Unlock()
+
+	s, ok := p.stats[name]
+	if !ok {
+		s = new(tenantStats)
+		p.stats[name] = s
+	}
+	s.queued++
+}
+
+func (p *fairPool) dispatched(item *fairItem) {
+	p.Lock()
+	defer p.Unlock()
+
+	s := p.stats[item.tenant]
+	s.queued--
+	s.count++
+	s.waited += time.Since(item.arrival)
+}
+
+type fairItem struct {
+	tenant  string
+	data    Data
+	arrival time.Time
+}
+
+type fairTenant struct {
+	name    string
+	weight  int
+	items   []*fairItem
+	deficit int
+	// visited is set once the tenant received its quantum in the current round
+	visited bool
+	active  bool
+}
+
+// fairQueue schedules the queued items of the tenants with deficit round robin.
+type fairQueue struct {
+	tenants map[string]*fairTenant
+	active  []*fairTenant
+	cur     int
+	queued  int
+}
+
+func (q *fairQueue) push(name string, weight int, data Data) {
+	t, ok := q.tenants[name]
+	if !ok {
+		t = &fairTenant{name: name, weight: weight}
+		q.tenants[name] = t
+	}
+	if !t.active {
+		t.active = true
+		q.active = append(q.active, t)
+	}
+
+	t.items = append(t.items, &fairItem{
+		tenant:  name,
+		data:    data,
+		arrival: time.Now(),
+	})
+	q.queued++
+}
+
+// next removes the item to be processed next, or returns nil if all the queues are empty.
+func (q *fairQueue) next() *fairItem {
+	for len(q.active) > 0 {
+		if q.cur >= len(q.active) {
+			q.cur = 0
+		}
+
+		t := q.active[q.cur]
+		if len(t.items) == 0 {
+			// An idle tenant does not keep its deficit
+			t.deficit, t.visited, t.active = 0, false, false
+			q.active = append(q.active[:q.cur], q.active[q.cur+1:]...)
+			continue
+		}
+
+		if !t.visited {
+			t.deficit += t.weight
+			t.visited = true
+		}
+		if t.deficit > 0 {
+			item := t.items[0]
+			t.items = t.items[1:]
+			t.deficit--
+			q.queued--
+			return item
+		}
+
+		t.visited = false
+		q.cur++
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFairPoolWeights(t *testing.T) {
+	var lock sync.Mutex
+	var order []string
+	release := make(chan struct{})
+	task := TaskFunc(func(_ context.Context, d Data) (Data, error) {
+		<-release
+
+		lock.Lock()
+		order = append(order, tenantOf(d))
+		lock.Unlock()
+		return d, nil
+	})
+
+	// Both tenants are backlogged once the first item is released
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+	}()
+
+	src := &sourceStub{data: tenantDataValues(8, "a", "b")}
+	sink := new(sinkStub)
+
+	stage := FairPool(task, 1, tenantOf, map[string]int{"a": 3})
+	if err := NewPipeline(stage).Execute(context.TODO(), src, sink); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+	if len(sink.data) != len(src.data) {
+		t.Errorf("Expected %d data to reach the sink, got %d", len(src.data), len(sink.data))
+	}
+
+	var a int
+	for _, name := range order[2:10] {
+		if name == "a" {
+			a++
+		}
+	}
+	if a < 5 {
+		t.Errorf("Expected tenant a to receive three times the share of tenant b, got the order %v", order)
+	}
+}
+
+func TestFairPoolWaitMetrics(t *testing.T) {
+	task := TaskFunc(func(_ context.Context, d Data) (Data, error) {
+		time.Sleep(time.Millisecond)
+		return d, nil
+	})
+
+	// The heavy tenant sends four times as much data as the light tenant
+	src := &sourceStub{data: tenantDataValues(10, "heavy", "heavy", "heavy", "heavy", "light")}
+
+	stage := FairPool(task, 1, tenantOf, nil)
+	if err := NewPipeline(stage).Execute(context.TODO(), src, new(sinkStub)); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+
+	metrics := stage.(MetricsReporter).Metrics()
+	heavy, light := metrics["tenant_heavy_wait_seconds"], metrics["tenant_light_wait_seconds"]
+	if light >= heavy {
+		t.Errorf("Expected the light tenant to wait less than the heavy tenant, got %v and %v", light, heavy)
+	}
+	if queued := metrics["tenant_heavy_queued"]; queued != 0 {
+		t.Errorf("Expected no queued data once the execution ended, got %v", queued)
+	}
+}
+
+func tenantOf(d Data) string {
+	var name string
+	fmt.Sscanf(d.(*stringData).val, "%s", &name)
+	return name
+}
+
+// tenantDataValues returns num rounds of data for the tenants in the provided order.
+func tenantDataValues(num int, tenants ...string) []Data {
+	var out []Data
+
+	for i := 0; i < num; i++ {
+		for _, name := range tenants {
+			out = append(out, &stringData{val: fmt.Sprintf("%s %d", name, i)})
+		}
+	}
+	return out
+}
@@ -1,9 +1,6 @@
 package pipeline
 
-import (
-	"context"
-	"fmt"
-)
+import "context"
 
 type fifo struct {
 	task Task
@@ -16,34 +13,73 @@ func FIFO(task Task) Stage {
 	return fifo{task: task}
 }
 
+func (r fifo) taskList() []Task { return []Task{r.task} }
+
 // Run implements Stage.
 func (r fifo) Run(ctx context.Context, sp StageParams) {
+	runFIFO(ctx, sp, chainTask(sp, r.task), nil)
+}
+
+// runFIFO passes each input to the task and emits the output. The gate
+// is shared by the workers of a pool that read from the same input.
+func runFIFO(ctx context.Context, sp StageParams, task Task, g *gate) {
 	for {
-		select {
-		case <-ctx.Done():
+		dataIn, ok := g.receive(ctx, sp.Input())
+		if !ok {
 			return
-		case dataIn, ok := <-sp.Input():
+		}
+
+		if c, ok := dataIn.(control); ok {
+			ok = punctuate(ctx, sp, task, c) && forwardControl(ctx, sp, c)
+			g.release()
 			if !ok {
 				return
 			}
+			continue
+		}
 
-			dataOut, err := r.task.Process(ctx, dataIn)
-			if err != nil {
-				sp.Error().Append(fmt.Errorf("pipeline stage %d: %v", sp.Position(), err))
-				return
-			}
-			// If the task did not output data for the
-			// next stage there is nothing we need to do
-			if dataOut == nil {
-				dataIn.MarkAsProcessed()
-				continue
-			}
-			// Output processed data
-			select {
-			case <-ctx.Done():
-				return
-			case sp.Output() <- dataOut:
-			}
+		ok = processFIFO(ctx, sp, task, dataIn)
+		g.done()
+		if !ok {
+			return
 		}
 	}
 }
+
+// processFIFO passes the input to the task and emits the output. It returns
+// false if the task failed or the context expired.
+func processFIFO(ctx context.Context, sp StageParams, task Task, dataIn Data) bool {
+	m := memoryOf(sp)
+	size := m.hold(sp.Position(), dataIn)
+
+	var dataOut Data
+	var err error
+	items := itemsOf(sp)
+	// An item cancelled on its own is discarded
+	ictx := items.context(ctx, dataIn)
+	if !itemCancelled(ctx, ictx) {
+		dataOut, err = task.Process(ictx, dataIn)
+	}
+	reason, ok := dropReason(ctx, ictx, dataOut, err)
+	if !ok {
+		sp.Error().Append(stageError(sp, sp.Position(), err))
+		return false
+	}
+	// If the task did not output data for the
+	// next stage there is nothing we need to do
+	if reason != "" {
+		dataIn.MarkAsProcessed()
+		m.drop(sp.Position(), size)
+		discard(sp, sp.Position(), dataIn, reason)
+		return true
+	}
+	m.emit(sp.Position(), size, dataOut)
+	items.forward(dataIn, dataOut)
+	// Output processed data
+	select {
+	case <-ctx.Done():
+		return false
+	case sp.Output() <- dataOut:
+	}
+	return true
+}
@@ -0,0 +1,169 @@
+package pipeline
+
+import "context"
+
+type fused struct {
+	tasks []Task
+}
+
+// FuseStages returns an Option that has the pipeline fuse each run of adjacent
+// FIFO stages into a single stage. The fused stage calls the Tasks one after
+// another from a single goroutine, which avoids the channel hop and goroutine
+// switch between the stages. Errors continue to report the position of the
+// original stage and a nil Task output still discards the data.
+func FuseStages() Option {
+	return func(p *Pipeline) {
+		p.fuse = true
+	}
+}
+
+// stagePositions returns the pipeline positions for num unfused stages.
+func stagePositions(num int) []int {
+	positions := make([]int, num)
+	for i := 0; i < num; i++ {
+		positions[i] = i + 1
+	}
+	return positions
+}
+
+// fuseStages replaces each run of adjacent FIFO stages with a single fused
+// stage and returns the pipeline position of the first stage in each run.
+// Runs are split at the links with a spill buffer.
+func fuseStages(stages []Stage, spills map[int]Spill) ([]Stage, []int) {
+	var out []Stage
+	var positions []int
+
+	for i := 0; i < len(stages); {
+		f, ok := stages[i].(fifo)
+		if !ok {
+			out = append(out, stages[i])
+			positions = append(positions, i+1)
+			i++
+			continue
+		}
+
+		tasks := []Task{f.task}
+		j := i + 1
+		for ; j < len(stages); j++ {
+			if _, spilled := spills[j]; spilled {
+				break
+			}
+
+			next, ok := stages[j].(fifo)
+			if !ok {
+				break
+			}
+			tasks = append(tasks, next.task)
+		}
+
+		if len(tasks) == 1 {
+			out = append(out, f)
+		} else {
+			out = append(out, &fused{tasks: tasks})
+		}
+		positions = append(positions, i+1)
+		i = j
+	}
+	return out, positions
+}
+
+func (f *fused) taskList() []Task { return f.tasks }
+
+// Run implements Stage.
+func (f *fused) Run(ctx context.Context, sp StageParams) {
+	// The middleware chain wraps the tasks of this execution
+	f = &fused{tasks: chainTasks(sp, f.tasks)}
+	// Each task keeps the stage-scoped values of its original position
+	ctxs := make([]context.Context, len(f.tasks))
+	for i := range f.tasks {
+		ctxs[i] = withStageInfo(ctx, sp, sp.Position()+i)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case dataIn, ok := <-sp.Input():
+			if !ok {
+				return
+			}
+
+			if c, ok := dataIn.(control); ok {
+				if !f.control(ctxs, sp, c) {
+					return
+				}
+				continue
+			}
+
+			if !f.process(ctxs, sp, 0, dataIn) {
+				return
+			}
+		}
+	}
+}
+
+// process passes the data through the tasks starting at index from and emits
+// the output. It returns false if a task failed or the context expired.
+func (f *fused) process(ctxs []context.Context, sp StageParams, from int, dataIn Data) bool {
+	m := memoryOf(sp)
+	size := m.hold(sp.Position(), dataIn)
+	items := itemsOf(sp)
+
+	dataOut := dataIn
+	for i := from; i < len(f.tasks); i++ {
+		var d Data
+		var err error
+		// An item cancelled on its own is discarded
+		ictx := items.context(ctxs[i], dataOut)
+		if !itemCancelled(ctxs[i], ictx) {
+			d, err = f.tasks[i].Process(ictx, dataOut)
+		}
+		reason, ok := dropReason(ctxs[i], ictx, d, err)
+		if !ok {
+			sp.Error().Append(stageError(sp, sp.Position()+i, err))
+			return false
+		}
+		// If the task did not output data for the
+		// next task there is nothing more to do
+		if reason != "" {
+			dataOut.MarkAsProcessed()
+			m.drop(sp.Position(), size)
+			discard(sp, sp.Position()+i, dataOut, reason)
+			return true
+		}
+		items.forward(dataOut, d)
+		dataOut = d
+	}
+	m.emit(sp.Position(), size, dataOut)
+	// Output processed data
+	select {
+	case <-ctxs[0].Done():
+		return false
+	case sp.Output() <- dataOut:
+	}
+	return true
+}
+
+// control handles the control record at each of the fused positions in order.
+// Data returned by a task for a Punctuation is processed by the following tasks.
+func (f *fused) control(ctxs []context.Context, sp StageParams, c control) bool {
+	for i, task := range f.tasks {
+		d, err := punctuateTask(ctxs[i], task, c)
+		if err != nil {
+			sp.Error().Append(stageError(sp, sp.Position()+i, err))
+			return false
+		}
+		if d != nil {
+			// Data emitted for a Punctuation has not been charged yet
+			memoryOf(sp).charge(d)
+			if !f.process(ctxs, sp, i+1, d) {
+				return false
+			}
+		}
+		// The first position is handled when the record is forwarded
+		if i > 0 {
+			c.atStage(ctxs[i], sp.Position()+i)
+		}
+	}
+	return forwardControl(ctxs[0], sp, c)
+}
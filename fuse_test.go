@@ -0,0 +1,122 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestFusedDataFlow(t *testing.T) {
+	stages := make([]Stage, 10)
+	for i := 0; i < len(stages); i++ {
+		stages[i] = FIFO(makePassthroughTask())
+	}
+
+	src := &sourceStub{data: stringDataValues(3)}
+	sink := new(sinkStub)
+
+	p := NewPipeline(stages...).With(FuseStages())
+	if err := p.Execute(context.TODO(), src, sink); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+	if !reflect.DeepEqual(sink.data, src.data) {
+		t.Errorf("Data does not match.\nWanted:%v\nGot:%v\n", src.data, sink.data)
+	}
+
+	assertAllProcessed(t, src.data)
+}
+
+func TestFusedErrorPosition(t *testing.T) {
+	stages := make([]Stage, 5)
+	for i := 0; i < len(stages); i++ {
+		stages[i] = FIFO(makePassthroughTask())
+	}
+	stages[3] = FIFO(TaskFunc(func(_ context.Context, _ Data) (Data, error) {
+		return nil, errors.New("task error")
+	}))
+
+	src := &sourceStub{data: stringDataValues(3)}
+	sink := new(sinkStub)
+
+	p := NewPipeline(stages...).With(FuseStages())
+	re := regexp.MustCompile("(?s).*pipeline stage 4: task error.*")
+	if err := p.Execute(context.TODO(), src, sink); err == nil || !re.MatchString(err.Error()) {
+		t.Errorf("Error did not match the expectation: %v", err)
+	}
+}
+
+func TestFusedDataDiscarding(t *testing.T) {
+	drop := TaskFunc(func(_ context.Context, _ Data) (Data, error) {
+		return nil, nil
+	})
+
+	src := &sourceStub{data: stringDataValues(3)}
+	sink := new(sinkStub)
+
+	p := NewPipeline(FIFO(makePassthroughTask()), FIFO(drop), FIFO(makePassthroughTask())).With(FuseStages())
+	if err := p.Execute(context.TODO(), src, sink); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+	if len(sink.data) != 0 {
+		t.Errorf("Expected all data to be discarded by stage task")
+	}
+
+	assertAllProcessed(t, src.data)
+}
+
+func TestFuseStages(t *testing.T) {
+	task := makePassthroughTask()
+	stages := []Stage{
+		FIFO(task),
+		FIFO(task),
+		Broadcast(task),
+		FIFO(task),
+		FIFO(task),
+		FIFO(task),
+	}
+
+	fusedStages, positions := fuseStages(stages, nil)
+	if len(fusedStages) != 3 {
+		t.Fatalf("Expected 3 stages after fusion, got %d", len(fusedStages))
+	}
+	if want := []int{1, 3, 4}; !reflect.DeepEqual(positions, want) {
+		t.Errorf("Positions do not match.\nWanted:%v\nGot:%v\n", want, positions)
+	}
+	if f, ok := fusedStages[2].(*fused); !ok || len(f.tasks) != 3 {
+		t.Errorf("Expected the last three FIFO stages to be fused")
+	}
+
+	// Stages are not fused across a link with a spill buffer
+	_, positions = fuseStages(stages, map[int]Spill{4: {}})
+	if want := []int{1, 3, 4, 5}; !reflect.DeepEqual(positions, want) {
+		t.Errorf("Positions do not match.\nWanted:%v\nGot:%v\n", want, positions)
+	}
+}
+
+func BenchmarkFIFOChain(b *testing.B) {
+	benchmarkFIFOChain(b, NewPipeline(makeFIFOChain(10)...))
+}
+
+func BenchmarkFusedFIFOChain(b *testing.B) {
+	benchmarkFIFOChain(b, NewPipeline(makeFIFOChain(10)...).With(FuseStages()))
+}
+
+func benchmarkFIFOChain(b *testing.B, p *Pipeline) {
+	src := &sourceStub{data: stringDataValues(b.N)}
+	sink := new(sinkStub)
+
+	b.ResetTimer()
+	if err := p.Execute(context.TODO(), src, sink); err != nil {
+		b.Errorf("Error executing the Pipeline: %v", err)
+	}
+}
+
+func makeFIFOChain(num int) []Stage {
+	stages := make([]Stage, num)
+	for i := 0; i < num; i++ {
+		stages[i] = FIFO(makePassthroughTask())
+	}
+	return stages
+}
@@ -0,0 +1,91 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrItemDropped is the error of a Future whose Data was discarded by
+// the pipeline without any output reaching the end of the pipeline.
+var ErrItemDropped = errors.New("item dropped")
+
+// ErrItemBatched is the error of a Future whose Data was merged into a Batch
+// by a batching stage. The output of the Batch is not part of the request, and
+// continues through the pipeline after the Future has been resolved.
+var ErrItemBatched = errors.New("item merged into a batch")
+
+// Future is the result of a request submitted to a running pipeline.
+type Future struct {
+	once    sync.Once
+	done    chan struct{}
+	results []Data
+	err     error
+}
+
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+func (f *Future) resolve(results []Data, err error) {
+	f.once.Do(func() {
+		switch {
+		case len(results) > 0:
+			f.results = results
+		case err != nil:
+			f.err = err
+		default:
+			f.err = ErrItemDropped
+		}
+		close(f.done)
+	})
+}
+
+// Done returns a channel that is closed once the Future has been resolved.
+func (f *Future) Done() <-chan struct{} { return f.done }
+
+// Wait waits for the Future to be resolved and returns the outputs derived
+// from the Data of the request, or the error that prevented any output from
+// reaching the end of the pipeline. The context only bounds the wait.
+func (f *Future) Wait(ctx context.Context) ([]Data, error) {
+	select {
+	case <-f.done:
+		return f.results, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Request submits the Data as a request and returns the Future resolved once
+// the Data and the copies made by fan-out stages have all reached the end of
+// the pipeline or been discarded. The outputs resolve the Future instead of
+// reaching the Stream, and the request context is attached to the item as with
+// WithItemContext. Data implementing Identifier can be withdrawn with
+// CancelItem, which resolves the Future with the cancellation. The Data must be
+// implemented by a pointer type to be followed through the pipeline. The
+// outputs of batching stages are not part of the request, which resolves with
+// ErrItemBatched once the Data has been merged into a Batch.
+func (i *Injector) Request(ctx context.Context, data Data) (*Future, error) {
+	req := &contextData{Data: data, ctx: ctx, future: newFuture()}
+	if cd, ok := data.(*contextData); ok {
+		req.Data, req.ctx = cd.Data, cd.ctx
+	}
+	if !trackable(req.Data) {
+		return nil, fmt.Errorf("pipeline request: %T cannot be followed through the pipeline", req.Data)
+	}
+
+	if err := i.Submit(ctx, req); err != nil {
+		return nil, err
+	}
+	return req.future, nil
+}
+
+// SubmitAndWait performs Request and waits for the Future to be resolved.
+func (i *Injector) SubmitAndWait(ctx context.Context, data Data) ([]Data, error) {
+	f, err := i.Request(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	return f.Wait(ctx)
+}
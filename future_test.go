@@ -0,0 +1,112 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSubmitAndWait(t *testing.T) {
+	upper := TaskFunc(func(_ context.Context, d Data) (Data, error) {
+		return &stringData{val: "out" + d.(*stringData).val}, nil
+	})
+	filter := TaskFunc(func(_ context.Context, d Data) (Data, error) {
+		if d.(*stringData).val == "outdrop" {
+			return nil, nil
+		}
+		return d, nil
+	})
+	inj, s := NewPipeline(FIFO(upper), Broadcast(filter, filter)).Start(context.TODO(), 1, SubmitBlock)
+
+	got, err := inj.SubmitAndWait(context.TODO(), &stringData{val: "1"})
+	if err != nil {
+		t.Errorf("Error waiting for the request: %v", err)
+	}
+	// Broadcast derives two outputs from the request
+	want := []Data{&stringData{val: "out1"}, &stringData{val: "out1"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Outputs do not match.\nWanted:%v\nGot:%v\n", want, got)
+	}
+
+	if _, err := inj.SubmitAndWait(context.TODO(), &stringData{val: "drop"}); !errors.Is(err, ErrItemDropped) {
+		t.Errorf("Expected ErrItemDropped, got %v", err)
+	}
+
+	// Only the data submitted without a Future reaches the Stream
+	if err := inj.Submit(context.TODO(), &stringData{val: "2"}); err != nil {
+		t.Errorf("Error submitting data: %v", err)
+	}
+	inj.Close()
+	var streamed []Data
+	for s.Next(context.TODO()) {
+		streamed = append(streamed, s.Data())
+	}
+	if err := s.Error(); err != nil || len(streamed) != 2 || streamed[0].(*stringData).val != "out2" {
+		t.Errorf("Unexpected Stream results %v, error %v", streamed, err)
+	}
+}
+
+func TestFutureCancelItem(t *testing.T) {
+	started := make(chan struct{})
+	task := TaskFunc(func(ctx context.Context, d Data) (Data, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	p := NewPipeline(FIFO(task))
+	inj, s := p.Start(context.TODO(), 1, SubmitBlock)
+	defer s.Close()
+
+	f, err := inj.Request(context.TODO(), &idData{val: "req"})
+	if err != nil {
+		t.Fatalf("Error submitting the request: %v", err)
+	}
+	<-started
+	if !p.CancelItem("req") {
+		t.Errorf("Expected the request to be in flight")
+	}
+	if _, err := f.Wait(context.TODO()); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the request to be cancelled, got %v", err)
+	}
+}
+
+func TestFutureExecutionError(t *testing.T) {
+	failing := TaskFunc(func(_ context.Context, d Data) (Data, error) {
+		return nil, errors.New("task error")
+	})
+	inj, s := NewPipeline(FIFO(failing)).Start(context.TODO(), 1, SubmitBlock)
+	defer s.Close()
+
+	if _, err := inj.SubmitAndWait(context.TODO(), &stringData{val: "1"}); OutcomeOf(err) != Failed {
+		t.Errorf("Expected the error of the failed execution, got %v", err)
+	}
+	if _, err := inj.Request(context.TODO(), stringValue("1")); err == nil {
+		t.Errorf("Expected an error for data that cannot be followed")
+	}
+}
+
+func TestFutureBatched(t *testing.T) {
+	inj, s := NewPipeline(FixedBatch(makePassthroughTask(), 2, 50*time.Millisecond)).Start(context.TODO(), 1, SubmitBlock)
+
+	got, err := inj.SubmitAndWait(context.TODO(), &stringData{val: "1"})
+	if errors.Is(err, ErrItemDropped) || !errors.Is(err, ErrItemBatched) || len(got) != 0 {
+		t.Errorf("Expected ErrItemBatched, got %v, error %v", got, err)
+	}
+
+	// The output of the batch reaches the Stream
+	inj.Close()
+	var streamed []Data
+	for s.Next(context.TODO()) {
+		streamed = append(streamed, s.Data())
+	}
+	if err := s.Error(); err != nil || len(streamed) != 1 {
+		t.Errorf("Unexpected Stream results %v, error %v", streamed, err)
+	}
+}
+
+type stringValue string
+
+func (s stringValue) Clone() Data      { return s }
+func (s stringValue) MarkAsProcessed() {}
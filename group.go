@@ -0,0 +1,187 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// StageGroup is a named sequence of Stage instances that can be reused as a
+// fragment of several pipelines. A StageGroup can be inserted anywhere a Stage
+// is accepted, and the pipeline runs each of its stages at its own position.
+// The stages of a group are named after the group and their index within the
+// group, such as "enrich/2", and the names are included in errors.
+type StageGroup struct {
+	name   string
+	stages []Stage
+}
+
+// Group returns a StageGroup with the provided name and stages.
+func Group(name string, stages ...Stage) *StageGroup {
+	return &StageGroup{name: name, stages: stages}
+}
+
+// Name returns the name of the group.
+func (g *StageGroup) Name() string { return g.name }
+
+// Run implements Stage. The pipeline runs the stages of the group separately,
+// so Run is only used when the group is executed outside of a pipeline, and
+// the stages then take the positions following the group position.
+func (g *StageGroup) Run(ctx context.Context, sp StageParams) {
+	stages, names := flattenStages(g.stages)
+
+	var wg sync.WaitGroup
+	in := sp.Input()
+	for i, stage := range stages {
+		out := sp.Output()
+		var ch chan Data
+		if i < len(stages)-1 {
+			ch = make(chan Data)
+			out = ch
+		}
+
+		wg.Add(1)
+		go func(stage Stage, pos int, in <-chan Data, ch chan Data) {
+			defer wg.Done()
+
+			stage.Run(ctx, &params{
+				stage:      pos,
+				inCh:       in,
+				outCh:      out,
+				errQueue:   sp.Error(),
+				memory:     memoryOf(sp),
+				items:      itemsOf(sp),
+				drops:      dropsOf(sp),
+				names:      groupNames(sp.Position(), g.name, names),
+				middleware: middlewareOf(sp),
+			})
+			if ch != nil {
+				close(ch)
+			}
+		}(stage, sp.Position()+i, in, ch)
+		in = ch
+	}
+	wg.Wait()
+}
+
+// groupNames returns the stage names by position for a group
+// executed outside of a pipeline at the provided position.
+func groupNames(position int, name string, names []string) []string {
+	out := make([]string, position-1, position-1+len(names))
+
+	for _, n := range names {
+		out = append(out, groupName(name, len(out)-position+2, n))
+	}
+	return out
+}
+
+func groupName(group string, index int, name string) string {
+	if name == "" {
+		return fmt.Sprintf("%s/%d", group, index)
+	}
+	return group + "/" + name
+}
+
+// flattenStages replaces each StageGroup with its stages and returns the name
+// of each stage. Stages outside of any group have no name.
+func flattenStages(stages []Stage) ([]Stage, []string) {
+	var out []Stage
+	var names []string
+
+	for _, stage := range stages {
+		g, ok := stage.(*StageGroup)
+		if !ok {
+			out = append(out, stage)
+			names = append(names, "")
+			continue
+		}
+
+		inner, innerNames := flattenStages(g.stages)
+		for i, n := range innerNames {
+			out = append(out, inner[i])
+			names = append(names, groupName(g.name, i+1, n))
+		}
+	}
+	return out, names
+}
+
+// distinctStages returns the stages with a copy of each Stage instance that is
+// already used at another position, so that the stages keeping state while
+// running are not shared by the positions.
+func distinctStages(used, stages []Stage) []Stage {
+	seen := make(map[Stage]struct{}, len(used)+len(stages))
+	for _, stage := range used {
+		if reusable(stage) {
+			seen[stage] = struct{}{}
+		}
+	}
+
+	out := make([]Stage, len(stages))
+	for i, stage := range stages {
+		if reusable(stage) {
+			if _, ok := seen[stage]; ok {
+				stage = cloneStage(stage)
+			}
+			seen[stage] = struct{}{}
+		}
+		out[i] = stage
+	}
+	return out
+}
+
+// reusable returns true if the same Stage instance can appear at several
+// positions. Only pointers are compared, as other types might not be comparable.
+func reusable(stage Stage) bool {
+	return stage != nil && reflect.TypeOf(stage).Kind() == reflect.Ptr
+}
+
+// Then appends the stages to the pipeline and returns it.
+func (p *Pipeline) Then(stages ...Stage) *Pipeline {
+	stages, names := flattenStages(stages)
+
+	p.stages = append(p.stages, distinctStages(p.stages, stages)...)
+	p.names = append(p.names, names...)
+	return p
+}
+
+// Concat returns a new pipeline running the stages of each of the pipelines
+// in order. The options of the pipelines are not carried over.
+func Concat(pipelines ...*Pipeline) *Pipeline {
+	p := NewPipeline()
+
+	for _, other := range pipelines {
+		p.stages = append(p.stages, distinctStages(p.stages, other.stages)...)
+		p.names = append(p.names, other.names...)
+	}
+	return p
+}
+
+// stageName returns the name of the stage at the position, if any.
+func stageName(sp StageParams, position int) string {
+	if names := namesOf(sp); position > 0 && position <= len(names) {
+		return names[position-1]
+	}
+	return ""
+}
+
+// namesOf returns the stage names available to the stage, if any.
+func namesOf(sp StageParams) []string {
+	if p, ok := sp.(*params); ok {
+		return p.names
+	}
+	return nil
+}
+
+// stageLabel describes the stage at the position for errors.
+func stageLabel(position int, name string) string {
+	if name == "" {
+		return fmt.Sprintf("stage %d", position)
+	}
+	return fmt.Sprintf("stage %d (%s)", position, name)
+}
+
+// stageError returns the error emitted by the stage at the position.
+func stageError(sp StageParams, position int, err error) error {
+	return fmt.Errorf("pipeline %s: %v", stageLabel(position, stageName(sp, position)), err)
+}
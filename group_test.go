@@ -0,0 +1,88 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestComposition(t *testing.T) {
+	var order []string
+	step := func(name string) Stage {
+		return FIFO(TaskFunc(func(_ context.Context, d Data) (Data, error) {
+			order = append(order, name)
+			return d, nil
+		}))
+	}
+
+	enrich := Group("enrich", step("b"), step("c"))
+	first := NewPipeline(step("a"), enrich)
+	second := NewPipeline(Group("store", step("d"))).Then(step("e"))
+
+	src := &sourceStub{data: stringDataValues(1)}
+	sink := new(sinkStub)
+
+	p := Concat(first, second)
+	if err := p.Execute(context.TODO(), src, sink); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+	if want := []string{"a", "b", "c", "d", "e"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("Stage order does not match.\nWanted:%v\nGot:%v\n", want, order)
+	}
+	if want := []string{"", "enrich/1", "enrich/2", "store/1", ""}; !reflect.DeepEqual(p.names, want) {
+		t.Errorf("Stage names do not match.\nWanted:%v\nGot:%v\n", want, p.names)
+	}
+	assertAllProcessed(t, src.data)
+}
+
+func TestGroupErrorPosition(t *testing.T) {
+	failing := FIFO(TaskFunc(func(_ context.Context, d Data) (Data, error) {
+		return nil, errors.New("some error")
+	}))
+	passthrough := FIFO(makePassthroughTask())
+
+	inner := Group("enrich", passthrough, failing)
+	p := NewPipeline(passthrough, Group("etl", passthrough, inner)).With(FuseStages())
+
+	err := p.Execute(context.TODO(), &sourceStub{data: stringDataValues(1)}, new(sinkStub))
+	if err == nil {
+		t.Fatalf("Expected the pipeline to fail")
+	}
+
+	re := regexp.MustCompile(`(?s).*pipeline stage 4 \(etl/enrich/2\): some error.*`)
+	if !re.MatchString(err.Error()) {
+		t.Errorf("Error did not match the expected regex: %v", err)
+	}
+}
+
+func TestRepeatedStageInstances(t *testing.T) {
+	pool := DynamicPool(makePassthroughTask(), 2)
+	group := Group("pool", pool)
+
+	tests := map[string]*Pipeline{
+		"group":  NewPipeline(group, group),
+		"then":   NewPipeline(pool).Then(pool),
+		"concat": func() *Pipeline { p := NewPipeline(pool); return Concat(p, p) }(),
+	}
+	for name, p := range tests {
+		if len(p.stages) != 2 || p.stages[0] == p.stages[1] {
+			t.Errorf("%s: the repeated stage instance was not copied", name)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		src := &sourceStub{data: stringDataValues(10)}
+		sink := new(sinkStub)
+
+		if err := p.Execute(ctx, src, sink); err != nil {
+			t.Errorf("%s: error executing the Pipeline: %v", name, err)
+		}
+		cancel()
+		if len(sink.data) != len(src.data) {
+			t.Errorf("%s: expected %d outputs, got %d", name, len(src.data), len(sink.data))
+		}
+	}
+}
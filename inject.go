@@ -0,0 +1,121 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrInjectorClosed is returned by Submit once the Injector has been
+// closed or the execution has ended.
+var ErrInjectorClosed = errors.New("injector closed")
+
+// ErrInjectorFull is returned by Submit in the SubmitFailFast mode
+// when the pipeline cannot accept more Data.
+var ErrInjectorFull = errors.New("injector full")
+
+// SubmitMode selects the behavior of Submit when the pipeline cannot accept more Data.
+type SubmitMode int
+
+const (
+	// SubmitBlock has Submit wait for the pipeline to accept the Data.
+	SubmitBlock SubmitMode = iota
+	// SubmitFailFast has Submit return ErrInjectorFull immediately.
+	SubmitFailFast
+)
+
+// Injector feeds the Data submitted by any number of goroutines to a running pipeline.
+type Injector struct {
+	lock    sync.RWMutex
+	mode    SubmitMode
+	ch      chan Data
+	data    Data
+	closed  bool
+	closing chan struct{}
+	once    sync.Once
+	// stopped is closed once the execution has ended
+	stopped <-chan struct{}
+}
+
+// Start starts executing the pipeline with the Data submitted to the returned
+// Injector, and returns the Stream providing the Data emitted by the execution.
+// Up to bufsize Data can wait for the pipeline before Submit applies the mode.
+// The execution ends once the Injector has been closed and the submitted Data
+// has been processed, or the Stream is closed.
+func (p *Pipeline) Start(ctx context.Context, bufsize int, mode SubmitMode) (*Injector, *Stream) {
+	inj := &Injector{
+		mode:    mode,
+		ch:      make(chan Data, bufsize),
+		closing: make(chan struct{}),
+	}
+
+	s := p.stream(ctx, func(ctx context.Context, sink OutputSink) error {
+		return p.Execute(ctx, inj, sink)
+	})
+	inj.stopped = s.done
+	return inj, s
+}
+
+// Submit sends the Data to the pipeline. It returns ErrInjectorClosed once the
+// Injector has been closed or the execution has ended, and the context error if
+// the context expires while waiting for the pipeline.
+func (i *Injector) Submit(ctx context.Context, data Data) error {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	if i.closed {
+		return ErrInjectorClosed
+	}
+
+	if i.mode == SubmitFailFast {
+		select {
+		case <-i.stopped:
+			return ErrInjectorClosed
+		case i.ch <- data:
+			return nil
+		default:
+			return ErrInjectorFull
+		}
+	}
+
+	select {
+	case <-i.stopped:
+		return ErrInjectorClosed
+	case <-i.closing:
+		return ErrInjectorClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	case i.ch <- data:
+	}
+	return nil
+}
+
+// Close signals the end of the input. The Data already submitted is still processed.
+func (i *Injector) Close() {
+	i.once.Do(func() {
+		// Release the blocked submitters before waiting for them
+		close(i.closing)
+
+		i.lock.Lock()
+		i.closed = true
+		close(i.ch)
+		i.lock.Unlock()
+	})
+}
+
+// Next implements the InputSource interface.
+func (i *Injector) Next(ctx context.Context) bool {
+	select {
+	case data, ok := <-i.ch:
+		i.data = data
+		return ok
+	case <-ctx.Done():
+	}
+	return false
+}
+
+// Data implements the InputSource interface.
+func (i *Injector) Data() Data { return i.data }
+
+// Error implements the InputSource interface.
+func (i *Injector) Error() error { return nil }
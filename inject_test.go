@@ -0,0 +1,98 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestInjector(t *testing.T) {
+	inj, s := NewPipeline(FixedPool(makePassthroughTask(), 2)).Start(context.TODO(), 1, SubmitBlock)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 10; j++ {
+				if err := inj.Submit(context.TODO(), &stringData{val: fmt.Sprintf("%d-%d", i, j)}); err != nil {
+					t.Errorf("Error submitting data: %v", err)
+				}
+			}
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		inj.Close()
+	}()
+
+	seen := make(map[string]bool)
+	for s.Next(context.TODO()) {
+		seen[s.Data().(*stringData).val] = true
+	}
+	if err := s.Error(); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+	if len(seen) != 100 {
+		t.Errorf("Expected 100 results, got %d", len(seen))
+	}
+	if err := inj.Submit(context.TODO(), &stringData{}); !errors.Is(err, ErrInjectorClosed) {
+		t.Errorf("Expected ErrInjectorClosed after closing the Injector, got %v", err)
+	}
+}
+
+func TestInjectorFailFast(t *testing.T) {
+	release := make(chan struct{})
+	task := TaskFunc(func(_ context.Context, d Data) (Data, error) {
+		<-release
+		return d, nil
+	})
+	inj, s := NewPipeline(FIFO(task)).Start(context.TODO(), 1, SubmitFailFast)
+
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		err = inj.Submit(context.TODO(), &stringData{val: fmt.Sprint(i)})
+	}
+	if !errors.Is(err, ErrInjectorFull) {
+		t.Errorf("Expected ErrInjectorFull once the pipeline was full, got %v", err)
+	}
+
+	close(release)
+	inj.Close()
+	for s.Next(context.TODO()) {
+	}
+	if err := s.Error(); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+}
+
+func TestInjectorBlockedSubmit(t *testing.T) {
+	task := TaskFunc(func(ctx context.Context, d Data) (Data, error) {
+		<-ctx.Done()
+		return nil, nil
+	})
+	inj, s := NewPipeline(FIFO(task)).Start(context.TODO(), 0, SubmitBlock)
+
+	if err := inj.Submit(context.TODO(), &stringData{}); err != nil {
+		t.Fatalf("Error submitting data: %v", err)
+	}
+	// The submitter blocks once the stage is busy and is released by Close
+	errs := make(chan error, 1)
+	go func() {
+		var err error
+		for err == nil {
+			err = inj.Submit(context.TODO(), &stringData{})
+		}
+		errs <- err
+	}()
+	inj.Close()
+	if err := <-errs; !errors.Is(err, ErrInjectorClosed) {
+		t.Errorf("Expected ErrInjectorClosed, got %v", err)
+	}
+	if err := s.Close(); OutcomeOf(err) != Cancelled {
+		t.Errorf("Expected the execution to be cancelled, got %v", err)
+	}
+}
@@ -0,0 +1,464 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// contextData is the Data returned by an InputSource to attach a context to the item.
+type contextData struct {
+	Data
+	ctx context.Context
+	// future is set for the Data of a request
+	future *Future
+}
+
+// WithItemContext returns the Data for an InputSource to attach the context to
+// the item. The pipeline derives a context for the item that carries the values
+// and the deadline of the provided context, and is cancelled when the provided
+// context is cancelled or the execution ends. The derived context is passed to
+// each Task processing the item and to the OutputSink, ahead of the values of the
+// stage. Outputs returned by a Task for the item keep its context, as do the
+// copies made by Broadcast. An item cancelled on its own is discarded, instead of
+// failing the execution. Contexts are only tracked for Data implemented by
+// pointer types.
+func WithItemContext(ctx context.Context, data Data) Data {
+	return &contextData{Data: data, ctx: ctx}
+}
+
+// Identifier is implemented by Data that can be cancelled with
+// Pipeline.CancelItem while moving through the pipeline.
+type Identifier interface {
+	// ID returns the identifier of the item.
+	ID() string
+}
+
+// item holds the context derived for a Data moving through the pipeline.
+type item struct {
+	id     string
+	ctx    context.Context
+	values context.Context
+	cancel context.CancelFunc
+	// refs counts the Data sharing the item
+	refs int
+	// future is resolved with the outputs once no Data shares the item
+	future  *Future
+	results []Data
+	// reason is the reason the first Data of the item was discarded for
+	reason string
+	// batched is set once a Data of the item has been merged into a Batch
+	batched bool
+	// spilled holds the Data replaced by the copies read back from a spill
+	// buffer, which is marked as processed once the item is released
+	spilled []Data
+	// source is set for the items of the Data read from the InputSource
+	// while the progress is reported, which is finished once released
+	source bool
+}
+
+func newItem(parent, values context.Context, id string) *item {
+	ctx, cancel := context.WithCancel(parent)
+	if deadline, ok := values.Deadline(); ok {
+		var dcancel context.CancelFunc
+
+		ctx, dcancel = context.WithDeadline(ctx, deadline)
+		pcancel := cancel
+		cancel = func() {
+			dcancel()
+			pcancel()
+		}
+	}
+	if values.Err() != nil {
+		cancel()
+	} else if done := values.Done(); done != nil {
+		go func(ctx context.Context) {
+			select {
+			case <-done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}(ctx)
+	}
+
+	return &item{
+		id:     id,
+		ctx:    ctx,
+		values: values,
+		cancel: cancel,
+		refs:   1,
+	}
+}
+
+// itemContext provides the cancellation of the item and its values
+// ahead of the values of the stage.
+type itemContext struct {
+	context.Context
+	values context.Context
+	stage  context.Context
+}
+
+func (c *itemContext) Value(key interface{}) interface{} {
+	if v := c.values.Value(key); v != nil {
+		return v
+	}
+	return c.stage.Value(key)
+}
+
+// itemTable tracks the items of an execution by Data.
+type itemTable struct {
+	sync.Mutex
+	items map[Data]*item
+	ids   map[string]*item
+	// pending holds the items with a Future yet to be resolved
+	pending map[*item]struct{}
+	// cancelled counts the items cancelled by ID
+	cancelled int
+	// size is read atomically to skip Data when no items are tracked
+	size int32
+	// progress is set when the progress of the execution is reported
+	progress *progressTracker
+}
+
+func newItemTable(progress *progressTracker) *itemTable {
+	return &itemTable{
+		items:    make(map[Data]*item),
+		ids:      make(map[string]*item),
+		pending:  make(map[*item]struct{}),
+		progress: progress,
+	}
+}
+
+// CancelItem cancels the context of the item with the ID in the latest
+// execution of the pipeline. Tasks processing the item observe the
+// cancellation, and the item is discarded at the next stage without
+// affecting the other items. It returns false if the item is not in flight.
+func (p *Pipeline) CancelItem(id string) bool {
+	p.lock.Lock()
+	items := p.items
+	p.lock.Unlock()
+
+	return items.cancel(id)
+}
+
+// itemsOf returns the item table available to the stage, if any.
+func itemsOf(sp StageParams) *itemTable {
+	if p, ok := sp.(*params); ok {
+		return p.items
+	}
+	return nil
+}
+
+// trackable returns true if the Data can be used as a key of the table.
+func trackable(data Data) bool {
+	return data != nil && reflect.TypeOf(data).Kind() == reflect.Ptr
+}
+
+func (t *itemTable) empty() bool {
+	return t == nil || atomic.LoadInt32(&t.size) == 0
+}
+
+// track unwraps the Data returned by the InputSource and starts tracking
+// its item when a context or an ID is provided.
+func (t *itemTable) track(ctx context.Context, data Data) Data {
+	var values context.Context
+	var future *Future
+	if cd, ok := data.(*contextData); ok {
+		data, values, future = cd.Data, cd.ctx, cd.future
+	}
+
+	var id string
+	if i, ok := data.(Identifier); ok {
+		id = i.ID()
+	}
+	if values == nil && id == "" {
+		return data
+	}
+	if values == nil {
+		values = context.Background()
+	}
+
+	t.add(ctx, data, values, id, future)
+	return data
+}
+
+// count follows the Data read from the InputSource through the pipeline, so it
+// is reported as finished once no Data shares its item. Data without an item
+// gets a new one, and Data that cannot be tracked is finished right away.
+func (t *itemTable) count(ctx context.Context, data Data) {
+	if t == nil || t.progress == nil {
+		return
+	}
+	if !trackable(data) {
+		t.progress.addFinished()
+		return
+	}
+
+	it := t.lookup(data)
+	if it == nil {
+		it = newItem(ctx, context.Background(), "")
+		t.attach(data, it)
+	}
+	t.Lock()
+	it.source = true
+	t.Unlock()
+}
+
+// add starts tracking the Data with a context derived from the execution context.
+func (t *itemTable) add(ctx context.Context, data Data, values context.Context, id string, future *Future) {
+	if t == nil || !trackable(data) {
+		return
+	}
+
+	it := newItem(ctx, values, id)
+	it.future = future
+	t.Lock()
+	if id != "" {
+		t.ids[id] = it
+	}
+	if future != nil {
+		t.pending[it] = struct{}{}
+	}
+	t.Unlock()
+	t.attach(data, it)
+}
+
+// cancel cancels the context of the item with the ID.
+func (t *itemTable) cancel(id string) bool {
+	if t == nil {
+		return false
+	}
+
+	t.Lock()
+	it, ok := t.ids[id]
+	if ok {
+		t.cancelled++
+	}
+	t.Unlock()
+
+	if ok {
+		it.cancel()
+	}
+	return ok
+}
+
+// attach has the Data share the item.
+func (t *itemTable) attach(data Data, it *item) {
+	if t == nil || it == nil || !trackable(data) {
+		return
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	if _, ok := t.items[data]; !ok {
+		atomic.AddInt32(&t.size, 1)
+	}
+	t.items[data] = it
+}
+
+// detach stops tracking the Data and returns its item without releasing it.
+func (t *itemTable) detach(data Data) *item {
+	if t.empty() || !trackable(data) {
+		return nil
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	it, ok := t.items[data]
+	if ok {
+		delete(t.items, data)
+		atomic.AddInt32(&t.size, -1)
+	}
+	return it
+}
+
+func (t *itemTable) lookup(data Data) *item {
+	if t.empty() || !trackable(data) {
+		return nil
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	return t.items[data]
+}
+
+// context returns the context for processing the Data within the provided stage context.
+func (t *itemTable) context(ctx context.Context, data Data) context.Context {
+	it := t.lookup(data)
+	if it == nil {
+		return ctx
+	}
+
+	return &itemContext{
+		Context: it.ctx,
+		values:  it.values,
+		stage:   ctx,
+	}
+}
+
+// forward moves the item of the Data to the output returned for it.
+func (t *itemTable) forward(data, out Data) {
+	if t.empty() || data == out {
+		return
+	}
+
+	if it := t.detach(data); it != nil {
+		if trackable(out) {
+			t.attach(out, it)
+		} else {
+			t.release(it)
+		}
+	}
+}
+
+// share has the copy of the Data share its item.
+func (t *itemTable) share(data, clone Data) {
+	it := t.lookup(data)
+	if it == nil || !trackable(clone) {
+		return
+	}
+
+	t.Lock()
+	it.refs++
+	t.Unlock()
+	t.attach(clone, it)
+}
+
+// deliver adds the Data to the outputs of its item when a Future awaits them.
+// It returns false if the Data is not part of a request.
+func (t *itemTable) deliver(data Data) bool {
+	it := t.lookup(data)
+	if it == nil || it.future == nil {
+		return false
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	it.results = append(it.results, data)
+	return true
+}
+
+// discard records the reason for discarding the Data and stops tracking it.
+func (t *itemTable) discard(data Data, reason string) {
+	it := t.lookup(data)
+	if it == nil {
+		return
+	}
+
+	t.Lock()
+	if it.reason == "" {
+		it.reason = reason
+	}
+	t.Unlock()
+	t.done(data)
+}
+
+// spill stops tracking the Data encoded into a segment file, and returns the item
+// for the copy decoded from the file. The Data is marked as processed once the
+// item is released. Data without an item gets a new one.
+func (t *itemTable) spill(ctx context.Context, data Data) *item {
+	it := t.detach(data)
+	if it == nil {
+		it = newItem(ctx, context.Background(), "")
+	}
+
+	t.Lock()
+	it.spilled = append(it.spilled, data)
+	t.Unlock()
+	return it
+}
+
+// merge records that the Data was merged into a Batch and stops tracking it.
+func (t *itemTable) merge(data Data) {
+	it := t.lookup(data)
+	if it == nil {
+		return
+	}
+
+	t.Lock()
+	it.batched = true
+	t.Unlock()
+	t.done(data)
+}
+
+// done stops tracking the Data once it has been consumed or discarded.
+func (t *itemTable) done(data Data) {
+	if it := t.detach(data); it != nil {
+		t.release(it)
+	}
+}
+
+// release cancels the context of the item once no Data shares it.
+func (t *itemTable) release(it *item) {
+	t.Lock()
+	it.refs--
+	last := it.refs <= 0
+	if last && it.id != "" && t.ids[it.id] == it {
+		delete(t.ids, it.id)
+	}
+	if last {
+		delete(t.pending, it)
+	}
+	reason, batched, spilled, source := it.reason, it.batched, it.spilled, it.source
+	t.Unlock()
+
+	if !last {
+		return
+	}
+	// An item cancelled on its own resolves with the cancellation
+	err := it.ctx.Err()
+	switch {
+	case err != nil:
+	case reason != "":
+		err = Drop(reason)
+	case batched:
+		err = ErrItemBatched
+	}
+	it.cancel()
+	if it.future != nil {
+		it.future.resolve(it.results, err)
+	}
+	for _, data := range spilled {
+		data.MarkAsProcessed()
+	}
+	if source {
+		t.progress.addFinished()
+	}
+}
+
+// close cancels the contexts of the items remaining at the end of the
+// execution, and resolves their Futures with the error of the execution.
+func (t *itemTable) close(err error) {
+	t.Lock()
+	defer t.Unlock()
+
+	for data, it := range t.items {
+		it.cancel()
+		delete(t.items, data)
+	}
+	for it := range t.pending {
+		it.cancel()
+		it.future.resolve(it.results, err)
+	}
+	t.ids = make(map[string]*item)
+	t.pending = make(map[*item]struct{})
+	atomic.StoreInt32(&t.size, 0)
+}
+
+func (t *itemTable) metrics(metrics map[string]float64) {
+	t.Lock()
+	defer t.Unlock()
+
+	metrics["items_cancelled"] = float64(t.cancelled)
+}
+
+// itemCancelled returns true if the item processed with ictx has been cancelled
+// on its own, while the execution context is still active.
+func itemCancelled(ctx, ictx context.Context) bool {
+	return ictx != ctx && ictx.Err() != nil && ctx.Err() == nil
+}
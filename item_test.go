@@ -0,0 +1,224 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type tenantKey struct{}
+
+func TestItemContextValues(t *testing.T) {
+	values := stringDataValues(4)
+	var data []Data
+	for i, d := range values {
+		data = append(data, WithItemContext(context.WithValue(context.Background(), tenantKey{}, fmt.Sprintf("tenant%d", i)), d))
+	}
+
+	var lock sync.Mutex
+	seen := make(map[string]string)
+	task := TaskFunc(func(ctx context.Context, d Data) (Data, error) {
+		if info, ok := StageInfoFromContext(ctx); !ok || info.Position != 2 {
+			return nil, fmt.Errorf("stage values are not available")
+		}
+
+		lock.Lock()
+		seen[d.(*stringData).val], _ = ctx.Value(tenantKey{}).(string)
+		lock.Unlock()
+		// The output keeps the context of the item
+		return &stringData{val: d.(*stringData).val}, nil
+	})
+	sink := new(contextSink)
+
+	p := NewPipeline(FIFO(makePassthroughTask()), FixedPool(task, 2), Broadcast(makePassthroughTask(), makePassthroughTask()))
+	if err := p.Execute(context.TODO(), &sourceStub{data: data}, sink); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+
+	want := map[string]string{"0": "tenant0", "1": "tenant1", "2": "tenant2", "3": "tenant3"}
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("Task values do not match.\nWanted:%v\nGot:%v\n", want, seen)
+	}
+	if len(sink.tenants) != 8 {
+		t.Fatalf("Expected 8 outputs, got %d", len(sink.tenants))
+	}
+	for val, tenant := range sink.tenants {
+		if tenant != "tenant"+val[:1] {
+			t.Errorf("Sink received %s with the values of %s", val, tenant)
+		}
+	}
+}
+
+func TestItemContextCancellation(t *testing.T) {
+	values := stringDataValues(3)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	blocked, unblock := context.WithCancel(context.Background())
+	defer unblock()
+	src := &sourceStub{data: []Data{
+		WithItemContext(context.Background(), values[0]),
+		WithItemContext(cancelled, values[1]),
+		WithItemContext(blocked, values[2]),
+	}}
+
+	task := TaskFunc(func(ctx context.Context, d Data) (Data, error) {
+		if d == values[2] {
+			// The task is interrupted by the cancellation of the item
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				unblock()
+			}()
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return d, nil
+	})
+	sink := new(sinkStub)
+
+	p := NewPipeline(FIFO(task))
+	if err := p.Execute(context.TODO(), src, sink); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+	if want := values[:1]; !reflect.DeepEqual(sink.data, want) {
+		t.Errorf("Data does not match.\nWanted:%v\nGot:%v\n", want, sink.data)
+	}
+
+	assertAllProcessed(t, values)
+}
+
+func TestItemContextDeadline(t *testing.T) {
+	values := stringDataValues(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	want, _ := ctx.Deadline()
+
+	var got time.Time
+	task := TaskFunc(func(ctx context.Context, d Data) (Data, error) {
+		got, _ = ctx.Deadline()
+		return d, nil
+	})
+
+	p := NewPipeline(FIFO(task))
+	if err := p.Execute(context.TODO(), &sourceStub{data: []Data{WithItemContext(ctx, values[0])}}, new(sinkStub)); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+	if !got.Equal(want) {
+		t.Errorf("Expected the deadline %v, got %v", want, got)
+	}
+}
+
+// contextSink records the tenant found in the context for each consumed Data.
+type contextSink struct {
+	tenants map[string]string
+}
+
+func (s *contextSink) Consume(ctx context.Context, d Data) error {
+	if s.tenants == nil {
+		s.tenants = make(map[string]string)
+	}
+
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	s.tenants[fmt.Sprintf("%s-%d", d.(*stringData).val, len(s.tenants))] = tenant
+	return nil
+}
+
+func TestCancelItem(t *testing.T) {
+	var data []Data
+	for i := 0; i < 4; i++ {
+		data = append(data, &idData{val: fmt.Sprint(i)})
+	}
+	src := &sourceStub{data: data}
+	sink := new(sinkStub)
+
+	var later int32
+	blocking := TaskFunc(func(ctx context.Context, d Data) (Data, error) {
+		if d.(*idData).val == "2" {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return d, nil
+	})
+	counting := TaskFunc(func(ctx context.Context, d Data) (Data, error) {
+		atomic.AddInt32(&later, 1)
+		return d, nil
+	})
+
+	p := NewPipeline(FixedPool(blocking, 2), FIFO(counting))
+	go func() {
+		for !p.CancelItem("2") {
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	if err := p.Execute(context.TODO(), src, sink); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+
+	if len(sink.data) != 3 || atomic.LoadInt32(&later) != 3 {
+		t.Errorf("Expected only the cancelled item to be discarded, got %v", sink.data)
+	}
+	for _, d := range sink.data {
+		if d.(*idData).val == "2" {
+			t.Errorf("The cancelled item reached the sink")
+		}
+	}
+	if m := p.Metrics(); m["items_cancelled"] != 1 {
+		t.Errorf("Expected 1 cancelled item, got %v", m["items_cancelled"])
+	}
+	if p.CancelItem("3") {
+		t.Errorf("Expected the item to be unknown once the execution ended")
+	}
+	for i, d := range data {
+		if !d.(*idData).processed {
+			t.Errorf("Data %d not processed", i)
+		}
+	}
+}
+
+func TestCancelItemBatched(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	values := stringDataValues(4)
+	data := []Data{values[0], WithItemContext(ctx, values[1]), values[2], values[3]}
+
+	var batched []string
+	task := TaskFunc(func(_ context.Context, d Data) (Data, error) {
+		for _, member := range d.(Batch) {
+			batched = append(batched, member.(*stringData).val)
+		}
+		return d, nil
+	})
+
+	var reasons []string
+	p := NewPipeline(FixedBatch(task, 4, 50*time.Millisecond)).With(OnDrop(func(ev DropEvent) {
+		reasons = append(reasons, ev.Reason)
+	}))
+	if err := p.Execute(context.TODO(), &sourceStub{data: data}, new(sinkStub)); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+
+	if want := []string{"0", "2", "3"}; !reflect.DeepEqual(batched, want) {
+		t.Errorf("Batch does not match.\nWanted:%v\nGot:%v\n", want, batched)
+	}
+	if want := []string{DropCancelled}; !reflect.DeepEqual(reasons, want) {
+		t.Errorf("Expected the cancelled item to be dropped, got %v", reasons)
+	}
+	assertAllProcessed(t, values)
+}
+
+// idData is a Data that can be cancelled by ID.
+type idData struct {
+	val       string
+	processed bool
+}
+
+func (d *idData) ID() string       { return d.val }
+func (d *idData) Clone() Data      { return &idData{val: d.val} }
+func (d *idData) MarkAsProcessed() { d.processed = true }
+func (d *idData) String() string   { return d.val }
@@ -0,0 +1,67 @@
+package pipeline
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyWindow keeps the most recent latency samples to estimate percentiles.
+type latencyWindow struct {
+	sync.Mutex
+	samples []time.Duration
+	next    int
+	full    bool
+}
+
+func newLatencyWindow(size int) *latencyWindow {
+	return &latencyWindow{samples: make([]time.Duration, size)}
+}
+
+// add records the latency sample, replacing the oldest one once the window is full.
+func (w *latencyWindow) add(d time.Duration) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.samples[w.next] = d
+	w.next++
+	if w.next == len(w.samples) {
+		w.next = 0
+		w.full = true
+	}
+}
+
+// count returns the number of samples in the window.
+func (w *latencyWindow) count() int {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.full {
+		return len(w.samples)
+	}
+	return w.next
+}
+
+// percentile returns the latency below which the fraction p of the samples fall.
+func (w *latencyWindow) percentile(p float64) time.Duration {
+	w.Lock()
+	n := w.next
+	if w.full {
+		n = len(w.samples)
+	}
+	sorted := append([]time.Duration(nil), w.samples[:n]...)
+	w.Unlock()
+
+	if n == 0 {
+		return 0
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	idx := int(p*float64(n)+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	} else if idx >= n {
+		idx = n - 1
+	}
+	return sorted[idx]
+}
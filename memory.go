@@ -0,0 +1,270 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Sizer is implemented by Data that can report the number of bytes it holds.
+// Data that does not implement Sizer is not counted against the MemoryBudget.
+type Sizer interface {
+	Size() int64
+}
+
+// MemoryBudget returns an Option that limits the bytes held by the Data in flight.
+// Once the budget has been used up, no more Data is read from the InputSource
+// until enough Data has reached the OutputSink or been discarded. The bytes held
+// by each Stage of this package are accounted for separately and reported by
+// the pipeline Metrics. Data received by other Stage implementations is not
+// counted until they emit it.
+func MemoryBudget(bytes int64) Option {
+	return func(p *Pipeline) {
+		p.memoryLimit = bytes
+	}
+}
+
+// sizeOf returns the number of bytes held by the Data.
+func sizeOf(data Data) int64 {
+	if s, ok := data.(Sizer); ok {
+		return s.Size()
+	}
+	return 0
+}
+
+// memoryBudget accounts for the bytes held by the Data in flight during an execution.
+type memoryBudget struct {
+	sync.Mutex
+	limit int64
+	inUse int64
+	held  map[int]int64
+	// freed is closed and replaced each time bytes are released
+	freed chan struct{}
+}
+
+func newMemoryBudget(limit int64) *memoryBudget {
+	if limit <= 0 {
+		return nil
+	}
+
+	return &memoryBudget{
+		limit: limit,
+		held:  make(map[int]int64),
+		freed: make(chan struct{}),
+	}
+}
+
+// memoryOf returns the memory budget available to the stage, if any.
+func memoryOf(sp StageParams) *memoryBudget {
+	if p, ok := sp.(*params); ok {
+		return p.memory
+	}
+	return nil
+}
+
+// admit blocks until the budget has room for the data. A single Data larger
+// than the budget is admitted once no other Data is in flight. It returns
+// false if the context expired in the meantime.
+func (m *memoryBudget) admit(ctx context.Context, data Data) bool {
+	if m == nil {
+		return true
+	}
+
+	size := sizeOf(data)
+	if size == 0 {
+		return true
+	}
+
+	for {
+		m.Lock()
+		if m.inUse == 0 || m.inUse+size <= m.limit {
+			m.inUse += size
+			m.Unlock()
+			return true
+		}
+		freed := m.freed
+		m.Unlock()
+
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// add changes the bytes in use without blocking.
+func (m *memoryBudget) add(n int64) {
+	if m == nil || n == 0 {
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.inUse += n
+	if n < 0 {
+		close(m.freed)
+		m.freed = make(chan struct{})
+	}
+}
+
+// charge counts the data against the budget without blocking.
+func (m *memoryBudget) charge(data Data) {
+	m.add(sizeOf(data))
+}
+
+// release returns the bytes held by the data to the budget.
+func (m *memoryBudget) release(data Data) {
+	m.add(-sizeOf(data))
+}
+
+// hold records that the data is held by the stage at position and returns its size.
+func (m *memoryBudget) hold(position int, data Data) int64 {
+	if m == nil {
+		return 0
+	}
+
+	size := sizeOf(data)
+	m.Lock()
+	m.held[position] += size
+	m.Unlock()
+	return size
+}
+
+// emit records that the stage at position replaced the data holding
+// size bytes with the output sent to the next stage.
+func (m *memoryBudget) emit(position int, size int64, out Data) {
+	if m == nil {
+		return
+	}
+
+	m.Lock()
+	m.held[position] -= size
+	m.Unlock()
+	m.add(sizeOf(out) - size)
+}
+
+// drop records that the stage at position discarded the data holding size bytes.
+func (m *memoryBudget) drop(position int, size int64) {
+	if m == nil {
+		return
+	}
+
+	m.Lock()
+	m.held[position] -= size
+	m.Unlock()
+	m.add(-size)
+}
+
+func (m *memoryBudget) metrics(metrics map[string]float64) {
+	m.Lock()
+	defer m.Unlock()
+
+	metrics["memory_budget_bytes"] = float64(m.limit)
+	metrics["memory_in_use_bytes"] = float64(m.inUse)
+	for position, held := range m.held {
+		metrics[fmt.Sprintf("stage_%d_held_bytes", position)] = float64(held)
+	}
+}
+
+// Metrics implements the MetricsReporter interface for the latest execution of the pipeline.
+func (p *Pipeline) Metrics() map[string]float64 {
+	metrics := make(map[string]float64)
+
+	p.lock.Lock()
+	memory := p.memory
+	items := p.items
+	drops := p.drops
+	p.lock.Unlock()
+	if memory != nil {
+		memory.metrics(metrics)
+	}
+	if items != nil {
+		items.metrics(metrics)
+	}
+	if drops != nil {
+		drops.metrics(metrics)
+	}
+	return metrics
+}
+
+// accountStages wraps the stages that cannot report the bytes they hold.
+func accountStages(stages []Stage) []Stage {
+	out := make([]Stage, len(stages))
+	for i, stage := range stages {
+		if accounted(stage) {
+			out[i] = stage
+		} else {
+			out[i] = &unaccounted{stage: stage}
+		}
+	}
+	return out
+}
+
+// accounted returns true if the Stage reports the bytes it holds.
+func accounted(stage Stage) bool {
+	if s, ok := stage.(*intercepted); ok {
+		return accounted(s.stage)
+	}
+
+	_, ok := stage.(taskStage)
+	return ok
+}
+
+// unaccounted runs a Stage implementation from outside of this package, which
+// cannot report the bytes it holds. The bytes are released when the data enters
+// the stage and charged again when the stage emits data.
+type unaccounted struct {
+	stage Stage
+}
+
+// Run implements Stage.
+func (u *unaccounted) Run(ctx context.Context, sp StageParams) {
+	m := memoryOf(sp)
+	inCh := make(chan Data)
+	outCh := make(chan Data)
+
+	go func() {
+		defer close(inCh)
+		relay(ctx, sp.Input(), inCh, m.release)
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		relay(ctx, outCh, sp.Output(), m.charge)
+	}()
+
+	u.stage.Run(ctx, &params{
+		stage:    sp.Position(),
+		inCh:     inCh,
+		outCh:    outCh,
+		errQueue: sp.Error(),
+		names:    namesOf(sp),
+	})
+	close(outCh)
+	<-done
+}
+
+// relay sends the data from the input to the output channel until
+// the input is closed or the context expires.
+func relay(ctx context.Context, in <-chan Data, out chan<- Data, f func(Data)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case data, ok := <-in:
+			if !ok {
+				return
+			}
+
+			f(data)
+			select {
+			case <-ctx.Done():
+				return
+			case out <- data:
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	var p *Pipeline
+	var lock sync.Mutex
+	var largest float64
+	task := TaskFunc(func(_ context.Context, d Data) (Data, error) {
+		lock.Lock()
+		if used := p.Metrics()["memory_in_use_bytes"]; used > largest {
+			largest = used
+		}
+		lock.Unlock()
+		return d, nil
+	})
+
+	src := &sourceStub{data: sizedDataValues(50, 10)}
+	sink := new(sinkStub)
+
+	p = NewPipeline(FIFO(task), FixedPool(task, 4), FIFO(task)).With(MemoryBudget(30))
+	if err := p.ExecuteBuffered(context.TODO(), src, sink, 10); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+	if len(sink.data) != len(src.data) {
+		t.Errorf("Expected %d data to reach the sink, got %d", len(src.data), len(sink.data))
+	}
+	if largest > 30 {
+		t.Errorf("Expected at most 30 bytes in flight, got %v", largest)
+	}
+
+	metrics := p.Metrics()
+	if used := metrics["memory_in_use_bytes"]; used != 0 {
+		t.Errorf("Expected all bytes to be released, got %v", used)
+	}
+	if _, ok := metrics["stage_1_held_bytes"]; !ok {
+		t.Errorf("Expected the bytes held by stage 1 to be reported")
+	}
+}
+
+func TestMemoryBudgetDiscarding(t *testing.T) {
+	task := TaskFunc(func(_ context.Context, d Data) (Data, error) {
+		if d.(*sizedData).val%2 == 0 {
+			return nil, nil
+		}
+		return d, nil
+	})
+
+	src := &sourceStub{data: sizedDataValues(20, 10)}
+	sink := new(sinkStub)
+
+	p := NewPipeline(testStage{t: t}, Broadcast(task, task), FixedBatch(makePassthroughTask(), 3, 0)).With(MemoryBudget(25))
+	if err := p.Execute(context.TODO(), src, sink); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+
+	var count int
+	for _, d := range sink.data {
+		count += len(d.(Batch))
+	}
+	if count != 20 {
+		t.Errorf("Expected 20 data to reach the sink, got %d", count)
+	}
+	if used := p.Metrics()["memory_in_use_bytes"]; used != 0 {
+		t.Errorf("Expected all bytes to be released, got %v", used)
+	}
+}
+
+type sizedData struct {
+	val  int
+	size int64
+}
+
+func (s *sizedData) Clone() Data      { return &sizedData{val: s.val, size: s.size} }
+func (s *sizedData) MarkAsProcessed() {}
+func (s *sizedData) Size() int64      { return s.size }
+func (s *sizedData) String() string   { return fmt.Sprint(s.val) }
+
+func sizedDataValues(num int, size int64) []Data {
+	out := make([]Data, num)
+
+	for i := 0; i < len(out); i++ {
+		out[i] = &sizedData{val: i, size: size}
+	}
+	return out
+}
@@ -0,0 +1,130 @@
+package pipeline
+
+import "context"
+
+// Middleware wraps a Task to add behavior around its Process calls, such as
+// logging, timing or recovery. The StageInfo of the stage calling the Task is
+// available from the context passed to Process.
+type Middleware func(next Task) Task
+
+// StageInfo describes the stage calling a Task.
+type StageInfo struct {
+	// Position is the position of the stage in the pipeline.
+	Position int
+
+	// Name is the name of the stage within its StageGroup, if any.
+	Name string
+}
+
+type stageInfoContextKey struct{}
+
+// StageInfoFromContext returns the StageInfo of the stage calling the Task.
+func StageInfoFromContext(ctx context.Context) (StageInfo, bool) {
+	info, ok := ctx.Value(stageInfoContextKey{}).(StageInfo)
+	return info, ok
+}
+
+// withStageInfo returns a context that provides the Tasks with
+// the StageInfo and the keyed state of the stage at position.
+func withStageInfo(ctx context.Context, sp StageParams, position int) context.Context {
+	return context.WithValue(withPosition(ctx, position), stageInfoContextKey{}, StageInfo{
+		Position: position,
+		Name:     stageName(sp, position),
+	})
+}
+
+// Use adds the middleware to the Tasks of every Stage of the pipeline and returns it.
+// The middleware is applied in order, so the first middleware is the outermost.
+func (p *Pipeline) Use(middleware ...Middleware) *Pipeline {
+	p.middleware = append(p.middleware, middleware...)
+	return p
+}
+
+type intercepted struct {
+	stage      Stage
+	middleware []Middleware
+}
+
+// Use returns the Stage with the middleware added to its Tasks, inside of the
+// middleware used by the pipeline. The middleware is applied by the Stage types
+// of this package, including the calls made for each copy of the Data by
+// Broadcast and Parallel, and is added to each stage of a StageGroup. Stages
+// with their own middleware are not fused.
+func Use(stage Stage, middleware ...Middleware) Stage {
+	if g, ok := stage.(*StageGroup); ok {
+		stages := make([]Stage, len(g.stages))
+		for i, s := range g.stages {
+			stages[i] = Use(s, middleware...)
+		}
+		return Group(g.name, stages...)
+	}
+
+	return &intercepted{stage: stage, middleware: middleware}
+}
+
+func (s *intercepted) taskList() []Task {
+	if ts, ok := s.stage.(taskStage); ok {
+		return ts.taskList()
+	}
+	return nil
+}
+
+// Run implements Stage.
+func (s *intercepted) Run(ctx context.Context, sp StageParams) {
+	p := &params{
+		stage:    sp.Position(),
+		inCh:     sp.Input(),
+		outCh:    sp.Output(),
+		errQueue: sp.Error(),
+	}
+	if orig, ok := sp.(*params); ok {
+		c := *orig
+		p = &c
+	}
+
+	p.middleware = append(append([]Middleware(nil), p.middleware...), s.middleware...)
+	s.stage.Run(ctx, p)
+}
+
+// middlewareOf returns the middleware applied by the stage, if any.
+func middlewareOf(sp StageParams) []Middleware {
+	if p, ok := sp.(*params); ok {
+		return p.middleware
+	}
+	return nil
+}
+
+// chainTask returns the Task wrapped by the middleware applied by the stage.
+func chainTask(sp StageParams, task Task) Task {
+	middleware := middlewareOf(sp)
+	if len(middleware) == 0 {
+		return task
+	}
+
+	next := task
+	for i := len(middleware) - 1; i >= 0; i-- {
+		next = middleware[i](next)
+	}
+	return &chained{Task: next, orig: task}
+}
+
+func chainTasks(sp StageParams, tasks []Task) []Task {
+	out := make([]Task, len(tasks))
+
+	for i, task := range tasks {
+		out[i] = chainTask(sp, task)
+	}
+	return out
+}
+
+// chained is a Task wrapped by middleware. It keeps providing the
+// optional interfaces implemented by the original Task.
+type chained struct {
+	Task
+	orig Task
+}
+
+// Punctuate implements the Punctuator interface.
+func (c *chained) Punctuate(ctx context.Context, pn *Punctuation) (Data, error) {
+	return punctuateTask(ctx, c.orig, pn)
+}
@@ -0,0 +1,94 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestPipelineMiddleware(t *testing.T) {
+	rec := new(stageRecorder)
+	task := makePassthroughTask()
+
+	src := &sourceStub{data: stringDataValues(5)}
+	sink := new(sinkStub)
+
+	p := NewPipeline(FIFO(task), Broadcast(task, task), Parallel(task, task), FixedPool(task, 2), DynamicPool(task, 2))
+	if err := p.Use(rec.middleware("p")).Execute(context.TODO(), src, sink); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+
+	// Broadcast emits two outputs for each input, which are each passed to both Parallel tasks
+	want := map[int]int{1: 5, 2: 10, 3: 20, 4: 10, 5: 10}
+	if got := rec.calls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Task calls by position do not match.\nWanted:%v\nGot:%v\n", want, got)
+	}
+}
+
+func TestStageMiddleware(t *testing.T) {
+	rec := new(stageRecorder)
+	task := makePassthroughTask()
+
+	src := &sourceStub{data: stringDataValues(1)}
+	group := Group("enrich", FIFO(task), FIFO(task))
+
+	p := NewPipeline(FIFO(task), Use(group, rec.middleware("s"))).Use(rec.middleware("p")).With(FuseStages())
+	if err := p.Execute(context.TODO(), src, new(sinkStub)); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+
+	want := []string{"p 1 ", "p 2 enrich/1", "s 2 enrich/1", "p 3 enrich/2", "s 3 enrich/2"}
+	if got := rec.seq; !reflect.DeepEqual(got, want) {
+		t.Errorf("Middleware calls do not match.\nWanted:%v\nGot:%v\n", want, got)
+	}
+}
+
+func TestMiddlewarePunctuation(t *testing.T) {
+	rec := new(stageRecorder)
+	batch := new(batchTask)
+
+	src := &sourceStub{data: makePunctuatedValues(6, 3)}
+	sink := new(sinkStub)
+
+	p := NewPipeline(Use(FIFO(batch), rec.middleware("s")))
+	if err := p.Execute(context.TODO(), src, sink); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+	if len(sink.data) != 2 || batch.punctuated != 2 {
+		t.Errorf("Expected the punctuation to reach the task wrapped by the middleware")
+	}
+}
+
+// stageRecorder provides middleware recording the StageInfo of each Task call.
+type stageRecorder struct {
+	sync.Mutex
+	seq []string
+	pos []int
+}
+
+func (s *stageRecorder) middleware(name string) Middleware {
+	return func(next Task) Task {
+		return TaskFunc(func(ctx context.Context, d Data) (Data, error) {
+			info, _ := StageInfoFromContext(ctx)
+
+			s.Lock()
+			s.seq = append(s.seq, fmt.Sprintf("%s %d %s", name, info.Position, info.Name))
+			s.pos = append(s.pos, info.Position)
+			s.Unlock()
+			return next.Process(ctx, d)
+		})
+	}
+}
+
+func (s *stageRecorder) calls() map[int]int {
+	s.Lock()
+	defer s.Unlock()
+
+	calls := make(map[int]int)
+	for _, pos := range s.pos {
+		calls[pos]++
+	}
+	return calls
+}
@@ -0,0 +1,91 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// Outcome classifies how a pipeline execution ended.
+type Outcome int
+
+// The possible outcomes of a pipeline execution.
+const (
+	// Completed means that all data from the InputSource has been processed.
+	Completed Outcome = iota
+	// Cancelled means that the context was cancelled before the execution completed.
+	Cancelled
+	// DeadlineExceeded means that the context deadline passed before the execution completed.
+	DeadlineExceeded
+	// Failed means that the execution was terminated by an error.
+	Failed
+)
+
+// String implements the Stringer interface.
+func (o Outcome) String() string {
+	switch o {
+	case Completed:
+		return "completed"
+	case Cancelled:
+		return "cancelled"
+	case DeadlineExceeded:
+		return "deadline exceeded"
+	case Failed:
+		return "failed"
+	}
+	return fmt.Sprintf("Outcome(%d)", int(o))
+}
+
+// ExecutionError is returned by a pipeline execution that did not complete.
+type ExecutionError struct {
+	// Outcome is Cancelled, DeadlineExceeded or Failed.
+	Outcome Outcome
+	// Cause is the context error for Cancelled and DeadlineExceeded,
+	// or all the errors that occurred during a Failed execution.
+	Cause error
+	// SourceExhausted reports whether all data had been read from the InputSource.
+	SourceExhausted bool
+}
+
+// Error implements the error interface.
+func (e *ExecutionError) Error() string {
+	return fmt.Sprintf("pipeline %v: %v", e.Outcome, e.Cause)
+}
+
+// Unwrap returns the cause of the unsuccessful execution.
+func (e *ExecutionError) Unwrap() error { return e.Cause }
+
+// OutcomeOf returns the Outcome of the pipeline execution that returned err.
+func OutcomeOf(err error) Outcome {
+	if err == nil {
+		return Completed
+	}
+
+	var eerr *ExecutionError
+	if errors.As(err, &eerr) {
+		return eerr.Outcome
+	}
+	return Failed
+}
+
+// outcome classifies the end of the execution from the emitted errors and the parent context.
+func (e *execution) outcome(parent context.Context, err error) error {
+	exhausted := atomic.LoadInt32(&e.exhausted) == 1
+
+	if err != nil {
+		return &ExecutionError{Outcome: Failed, Cause: err, SourceExhausted: exhausted}
+	}
+	if exhausted && atomic.LoadInt32(&e.drained) == e.sinkRunners {
+		return nil
+	}
+
+	if cerr := parent.Err(); cerr != nil {
+		outcome := Cancelled
+		if errors.Is(cerr, context.DeadlineExceeded) {
+			outcome = DeadlineExceeded
+		}
+		return &ExecutionError{Outcome: outcome, Cause: cerr, SourceExhausted: exhausted}
+	}
+	return nil
+}
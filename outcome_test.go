@@ -0,0 +1,94 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOutcomeCompleted(t *testing.T) {
+	src := &sourceStub{data: stringDataValues(3)}
+	sink := new(sinkStub)
+
+	p := NewPipeline(FIFO(makePassthroughTask()))
+	if err := p.Execute(context.TODO(), src, sink); OutcomeOf(err) != Completed {
+		t.Errorf("Expected the execution to complete, got %v", err)
+	}
+}
+
+func TestOutcomeCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	task := TaskFunc(func(_ context.Context, d Data) (Data, error) {
+		cancel()
+		return d, nil
+	})
+
+	src := &sourceStub{data: stringDataValues(100)}
+	sink := new(sinkStub)
+
+	err := NewPipeline(FIFO(task)).Execute(ctx, src, sink)
+	assertOutcome(t, err, Cancelled, context.Canceled, false)
+}
+
+func TestOutcomeDeadlineExceeded(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	task := TaskFunc(func(ctx context.Context, d Data) (Data, error) {
+		<-ctx.Done()
+		return d, nil
+	})
+
+	src := &sourceStub{data: stringDataValues(100)}
+	sink := new(sinkStub)
+
+	err := NewPipeline(FIFO(task)).Execute(ctx, src, sink)
+	assertOutcome(t, err, DeadlineExceeded, context.DeadlineExceeded, false)
+}
+
+func TestOutcomeContextAwareSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := NewPipeline(FIFO(makePassthroughTask())).Execute(ctx, new(endlessSource), new(sinkStub))
+	assertOutcome(t, err, DeadlineExceeded, context.DeadlineExceeded, false)
+}
+
+// endlessSource provides Data until the context expires.
+type endlessSource struct{}
+
+func (s *endlessSource) Next(ctx context.Context) bool { return ctx.Err() == nil }
+func (s *endlessSource) Data() Data                    { return &stringData{val: "x"} }
+func (s *endlessSource) Error() error                  { return nil }
+
+func TestOutcomeFailed(t *testing.T) {
+	serr := errors.New("source error")
+	src := &sourceStub{err: serr}
+	sink := new(sinkStub)
+
+	err := NewPipeline(FIFO(makePassthroughTask())).Execute(context.TODO(), src, sink)
+	assertOutcome(t, err, Failed, nil, false)
+
+	src = &sourceStub{data: stringDataValues(1)}
+	sink = &sinkStub{err: errors.New("sink error")}
+
+	err = NewPipeline(FIFO(makePassthroughTask())).Execute(context.TODO(), src, sink)
+	assertOutcome(t, err, Failed, nil, true)
+}
+
+func assertOutcome(t *testing.T, err error, outcome Outcome, cause error, exhausted bool) {
+	var eerr *ExecutionError
+	if !errors.As(err, &eerr) {
+		t.Fatalf("Expected an ExecutionError, got %v", err)
+	}
+	if eerr.Outcome != outcome || OutcomeOf(err) != outcome {
+		t.Errorf("Outcome does not match.\nWanted:%v\nGot:%v\n", outcome, eerr.Outcome)
+	}
+	if cause != nil && !errors.Is(err, cause) {
+		t.Errorf("Expected the error to wrap %v, got %v", cause, err)
+	}
+	if eerr.SourceExhausted != exhausted {
+		t.Errorf("Expected the source exhaustion to be %t", exhausted)
+	}
+}
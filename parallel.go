@@ -2,7 +2,7 @@ package pipeline
 
 import (
 	"context"
-	"fmt"
+	"sync/atomic"
 )
 
 type parallel struct {
@@ -21,8 +21,28 @@ func Parallel(tasks ...Task) Stage {
 	return &parallel{tasks: tasks}
 }
 
+func (p *parallel) taskList() []Task { return p.tasks }
+
+// punctuate delivers the control record to all the tasks. As with the Data,
+// only the control record is passed through to the following stage.
+func (p *parallel) punctuate(ctx context.Context, sp StageParams, c control) bool {
+	for _, task := range p.tasks {
+		d, err := punctuateTask(ctx, task, c)
+		if err != nil {
+			sp.Error().Append(stageError(sp, sp.Position(), err))
+			return false
+		}
+		if d != nil {
+			d.MarkAsProcessed()
+		}
+	}
+	return true
+}
+
 // Run implements Stage.
 func (p *parallel) Run(ctx context.Context, sp StageParams) {
+	// The middleware chain wraps the tasks of this execution
+	p = &parallel{tasks: chainTasks(sp, p.tasks)}
 loop:
 	for {
 		select {
@@ -33,28 +53,58 @@ loop:
 				return
 			}
 
-			done := make(chan Data, len(p.tasks))
+			if c, ok := data.(control); ok {
+				if !p.punctuate(ctx, sp, c) || !forwardControl(ctx, sp, c) {
+					return
+				}
+				continue
+			}
+
+			m := memoryOf(sp)
+			size := m.hold(sp.Position(), data)
+			items := itemsOf(sp)
+			// The copies are processed with the context of the item
+			ictx := items.context(ctx, data)
+
+			// Each task reports the reason for discarding its copy, if any
+			done := make(chan string, len(p.tasks))
+			var failed int32
 			for i := 0; i < len(p.tasks); i++ {
 				go func(idx int, clone Data) {
-					d, err := p.tasks[idx].Process(ctx, clone)
-					if err != nil {
-						sp.Error().Append(fmt.Errorf("pipeline stage %d: %v", sp.Position(), err))
+					var d Data
+					var err error
+					if !itemCancelled(ctx, ictx) {
+						d, err = p.tasks[idx].Process(ictx, clone)
+					}
+					reason, ok := dropReason(ctx, ictx, d, err)
+					if !ok {
+						sp.Error().Append(stageError(sp, sp.Position(), err))
+						atomic.StoreInt32(&failed, 1)
 					}
 					clone.MarkAsProcessed()
-					done <- d
+					done <- reason
 				}(i, data.Clone())
 			}
 
-			var failed bool
+			var reason string
 			for i := 0; i < len(p.tasks); i++ {
-				if d := <-done; d == nil {
-					failed = true
+				if r := <-done; r != "" && reason == "" {
+					reason = r
 				}
 			}
-			if failed {
+			// A failed task ends the stage without discarding the data
+			if atomic.LoadInt32(&failed) == 1 {
+				data.MarkAsProcessed()
+				m.drop(sp.Position(), size)
+				return
+			}
+			if reason != "" {
 				data.MarkAsProcessed()
+				m.drop(sp.Position(), size)
+				discard(sp, sp.Position(), data, reason)
 				continue loop
 			}
+			m.emit(sp.Position(), size, data)
 
 			select {
 			case <-ctx.Done():
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/caffix/queue"
 	"github.com/hashicorp/go-multierror"
@@ -14,6 +16,15 @@ type params struct {
 	inCh     <-chan Data
 	outCh    chan<- Data
 	errQueue *queue.Queue
+	memory   *memoryBudget
+	items    *itemTable
+	drops    *dropTracker
+	// names holds the name of the stage at each position
+	names      []string
+	middleware []Middleware
+	// fence is set for stages running inside another stage and
+	// is called instead of forwarding a control record
+	fence func()
 }
 
 func (p *params) Position() int       { return p.stage }
@@ -26,13 +37,83 @@ func (p *params) Error() *queue.Queue { return p.errQueue }
 // is constructed from an InputSource, an OutputSink, and zero
 // or more Stage instances for processing.
 type Pipeline struct {
-	stages []Stage
+	stages     []Stage
+	names      []string
+	middleware []Middleware
+	fuse       bool
+	deadLetter OutputSink
+	maxSkipped int
+	budget     *Budget
+	state      *stateStore
+
+	checkpoints        CheckpointStore
+	checkpointInterval time.Duration
+
+	preflightTimeout time.Duration
+	memoryLimit      int64
+	spills           map[int]Spill
+	progressInterval time.Duration
+	progress         func(Progress)
+	dropObserver     func(DropEvent)
+
+	lock sync.Mutex
+	// memory accounts for the bytes in flight during the latest execution
+	memory *memoryBudget
+	// items tracks the items in flight during the latest execution
+	items *itemTable
+	// drops counts the Data discarded during the latest execution
+	drops *dropTracker
 }
 
+// execution holds the state shared by the goroutines
+// of a single pipeline execution.
+type execution struct {
+	*Pipeline
+	errQueue *queue.Queue
+	budget   *budgetTracker
+	skipped  int
+	// checkpointer is set when the Checkpointing option has been provided
+	checkpointer *checkpointer
+	// txnSink is set when the OutputSink is a TransactionalSink
+	txnSink TransactionalSink
+	txnID   uint64
+	txnOpen bool
+	// memory is set when the MemoryBudget option has been provided
+	memory *memoryBudget
+	// items tracks the contexts attached to the Data by the InputSource
+	items *itemTable
+	// progress is set when the ProgressReporting option has been provided
+	progress *progressTracker
+	drops    *dropTracker
+	// exhausted is set atomically once the InputSource has no more data and
+	// drained counts the OutputSink runners that have consumed all data
+	exhausted   int32
+	drained     int32
+	sinkRunners int32
+}
+
+// Option configures optional behavior of a Pipeline.
+type Option func(*Pipeline)
+
 // NewPipeline returns a new data pipeline instance where input
-// traverse each of the provided Stage instances.
+// traverse each of the provided Stage instances. The stages of
+// each StageGroup are inserted in place of the group.
 func NewPipeline(stages ...Stage) *Pipeline {
-	return &Pipeline{stages: stages}
+	stages, names := flattenStages(stages)
+
+	return &Pipeline{
+		stages:     distinctStages(nil, stages),
+		names:      names,
+		maxSkipped: -1,
+	}
+}
+
+// With applies the provided options to the pipeline and returns it.
+func (p *Pipeline) With(opts ...Option) *Pipeline {
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
 }
 
 // Execute performs ExecuteBuffered with a bufsize parameter equal to 1.
@@ -42,104 +123,375 @@ func (p *Pipeline) Execute(ctx context.Context, src InputSource, sink OutputSink
 
 // ExecuteBuffered reads data from the InputSource, sends them through
 // each of the Stage instances, and finishes with the OutputSink.
-// All errors are returned that occurred during the execution.
-// ExecuteBuffered will block until all data from the InputSource has
-// been processed, or an error occurs, or the context expires.
+// The preflight checks are performed before any data is read from the
+// InputSource. ExecuteBuffered will block until all data from the InputSource
+// has been processed, or an error occurs, or the context expires, and then
+// waits for the InputSource, Stage instances and OutputSink to return. A nil
+// error is only returned once all the data has been processed. Otherwise,
+// an *ExecutionError classifies the Outcome and holds all errors that
+// occurred during the execution.
 func (p *Pipeline) ExecuteBuffered(ctx context.Context, src InputSource, sink OutputSink, bufsize int) error {
-	var cancel context.CancelFunc
-	ctx, cancel = context.WithCancel(ctx)
+	return p.execute(ctx, src, []OutputSink{sink}, 1, nil, bufsize)
+}
 
-	// Create channels for wiring together the InputSource, the pipeline
-	// Stage instances, and the OutputSink
-	stageCh := make([]chan Data, len(p.stages)+1)
-	for i := 0; i < len(stageCh); i++ {
-		stageCh[i] = make(chan Data, bufsize)
+// execute runs the shards of the pipeline, each with its own copy of the
+// Stage instances, and partitions the data from the InputSource by key.
+func (p *Pipeline) execute(ctx context.Context, src InputSource, sinks []OutputSink, shards int, key func(Data) string, bufsize int) error {
+	if err := p.preflight(ctx, src, sinks...); err != nil {
+		return &ExecutionError{Outcome: Failed, Cause: err}
 	}
+
 	errQueue := queue.NewQueue()
+	progress := newProgressTracker(p.progressInterval, p.progress, src)
+	ex := &execution{
+		Pipeline:    p,
+		errQueue:    errQueue,
+		budget:      newBudgetTracker(p.budget),
+		memory:      newMemoryBudget(p.memoryLimit),
+		items:       newItemTable(progress),
+		progress:    progress,
+		drops:       newDropTracker(p.dropObserver, progress),
+		sinkRunners: int32(len(sinks)),
+	}
+	p.lock.Lock()
+	p.memory = ex.memory
+	p.items = ex.items
+	p.drops = ex.drops
+	p.lock.Unlock()
+	if p.checkpoints != nil {
+		ex.checkpointer = &checkpointer{
+			store:    p.checkpoints,
+			state:    p.state,
+			interval: p.checkpointInterval,
+			last:     time.Now(),
+		}
+		cp, err := ex.restoreCheckpoint(src)
+		if err != nil {
+			return &ExecutionError{Outcome: Failed, Cause: fmt.Errorf("pipeline checkpoint restore: %v", err)}
+		}
+		if err := ex.beginTxn(ctx, sinks[0], cp); err != nil {
+			return &ExecutionError{Outcome: Failed, Cause: fmt.Errorf("pipeline output sink: %v", err)}
+		}
+	} else {
+		for _, sink := range sinks {
+			if _, ok := sink.(TransactionalSink); ok {
+				return &ExecutionError{Outcome: Failed, Cause: fmt.Errorf("pipeline output sink: %w", ErrNoCheckpointing)}
+			}
+		}
+		if p.state != nil {
+			if err := p.state.restore(); err != nil {
+				return &ExecutionError{Outcome: Failed, Cause: fmt.Errorf("pipeline state restore: %v", err)}
+			}
+		}
+	}
+
+	spills := make([]map[int]*spillBuffer, shards)
+	for i := 0; i < shards; i++ {
+		var err error
+
+		if spills[i], err = ex.spillBuffers(); err != nil {
+			for _, buffers := range spills[:i] {
+				for _, b := range buffers {
+					b.cleanup()
+				}
+			}
+			return &ExecutionError{Outcome: Failed, Cause: err}
+		}
+	}
+
+	parent := ctx
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithCancel(ctx)
 
 	var wg sync.WaitGroup
-	// Start a goroutine for each Stage
-	for i := 0; i < len(p.stages); i++ {
-		wg.Add(1)
-		go func(idx int) {
-			p.stages[idx].Run(ctx, &params{
-				stage:    idx + 1,
-				inCh:     stageCh[idx],
-				outCh:    stageCh[idx+1],
-				errQueue: errQueue,
-			})
-			// Tell the next Stage that no more Data is available
-			close(stageCh[idx+1])
-			wg.Done()
-		}(i)
+	// Start the Stage instances of each shard
+	heads := make([]chan Data, shards)
+	tails := make([]<-chan Data, shards)
+	for i := 0; i < shards; i++ {
+		stages := p.stages
+		if i > 0 {
+			stages = cloneStages(stages)
+		}
+		heads[i], tails[i] = ex.startStages(ctx, &wg, stages, spills[i], bufsize)
 	}
 
 	// Start goroutines for the InputSource and OutputSink
-	wg.Add(2)
+	wg.Add(1)
 	go func() {
-		inputSourceRunner(ctx, src, stageCh[0], errQueue)
+		ex.inputSourceRunner(ctx, src, heads, shardFunc(shards, key))
 		// Tell the next Stage that no more Data is available
-		close(stageCh[0])
+		for _, ch := range heads {
+			close(ch)
+		}
 		wg.Done()
 	}()
 
-	go func() {
-		outputSinkRunner(ctx, sink, stageCh[len(stageCh)-1], errQueue)
-		wg.Done()
-	}()
+	if len(sinks) < shards {
+		tails = []<-chan Data{mergeOutputs(ctx, &wg, tails, bufsize)}
+	}
+	for i, sink := range sinks {
+		wg.Add(1)
+		go func(sink OutputSink, inCh <-chan Data) {
+			ex.outputSinkRunner(ctx, sink, inCh)
+			wg.Done()
+		}(sink, tails[i])
+	}
 
 	// Monitor for completion of the pipeline execution
+	done := make(chan struct{})
 	go func() {
 		wg.Wait()
 		cancel()
+		close(done)
 	}()
+	reported := ex.progress.run(done)
 
-	var err error
-	// Collect any emitted errors and wrap them in a multi-error
+	// Wait for an error to be emitted or the execution to end
 	select {
 	case <-ctx.Done():
 	case <-errQueue.Signal:
-		errQueue.Process(func(e interface{}) {
-			if qErr, ok := e.(error); ok {
-				err = multierror.Append(err, qErr)
-			}
-		})
-		cancel()
 	}
+	cancel()
+
+	var err error
+	// Collect any emitted errors and wrap them in a multi-error
+	errQueue.Process(func(e interface{}) {
+		if qErr, ok := e.(error); ok {
+			err = multierror.Append(err, qErr)
+		}
+	})
+	// Make sure no stage is still operating on the data or the keyed state
+	<-done
+	<-reported
+	if p.state != nil && p.checkpoints == nil {
+		if serr := p.state.snapshot(); serr != nil {
+			err = multierror.Append(err, fmt.Errorf("pipeline state snapshot: %v", serr))
+		}
+	}
+
+	err = ex.outcome(parent, err)
+	ex.items.close(err)
 	return err
 }
 
-// inputSourceRunner drives the InputSource to continue providing
-// data to the first stage of the pipeline.
-func inputSourceRunner(ctx context.Context, src InputSource, outCh chan<- Data, errQueue *queue.Queue) {
+// startStages starts a goroutine for each Stage and each link with a spill buffer.
+// It returns the channel for the input of the first Stage and the channel
+// providing the output of the last Stage.
+func (e *execution) startStages(ctx context.Context, wg *sync.WaitGroup, stages []Stage, spills map[int]*spillBuffer, bufsize int) (chan Data, <-chan Data) {
+	positions := stagePositions(len(stages))
+	if e.fuse {
+		stages, positions = fuseStages(stages, e.spills)
+	}
+	if e.memory != nil {
+		stages = accountStages(stages)
+	}
+
+	// Create channels for wiring together the InputSource, the pipeline
+	// Stage instances, and the OutputSink
+	stageCh := make([]chan Data, len(stages)+1)
+	inputs := make([]<-chan Data, len(stageCh))
+	for i := 0; i < len(stageCh); i++ {
+		stageCh[i] = make(chan Data, bufsize)
+		inputs[i] = stageCh[i]
+	}
+
+	// Start a goroutine for each link with a spill buffer
+	for i := 0; i < len(stageCh); i++ {
+		link := len(e.stages)
+		if i < len(positions) {
+			link = positions[i] - 1
+		}
+
+		b, ok := spills[link]
+		if !ok {
+			continue
+		}
+
+		out := make(chan Data, bufsize)
+		inputs[i] = out
+		wg.Add(1)
+		go func(in <-chan Data) {
+			b.run(ctx, in, out, e.errQueue)
+			close(out)
+			wg.Done()
+		}(stageCh[i])
+	}
+
+	// Start a goroutine for each Stage
+	for i := 0; i < len(stages); i++ {
+		wg.Add(1)
+		go func(idx int) {
+			sp := &params{
+				stage:      positions[idx],
+				inCh:       inputs[idx],
+				outCh:      stageCh[idx+1],
+				errQueue:   e.errQueue,
+				memory:     e.memory,
+				items:      e.items,
+				drops:      e.drops,
+				names:      e.names,
+				middleware: e.middleware,
+			}
+			stages[idx].Run(withStageInfo(stageContext(ctx, e.state, positions[idx]), sp, positions[idx]), sp)
+			// Tell the next Stage that no more Data is available
+			close(stageCh[idx+1])
+			wg.Done()
+		}(i)
+	}
+	return stageCh[0], inputs[len(inputs)-1]
+}
+
+// inputSourceRunner drives the InputSource to continue providing data to the
+// first stage of the shard chosen for the data. Control records are sent to
+// the first stage of every shard.
+func (e *execution) inputSourceRunner(ctx context.Context, src InputSource, outs []chan Data, shard func(Data) int) {
 	for src.Next(ctx) {
 		data := src.Data()
+		data = e.items.track(ctx, data)
+		if _, ok := data.(control); !ok {
+			e.budget.record()
+			e.progress.addRead()
+		}
 
-		select {
-		case outCh <- data:
-		case <-ctx.Done():
+		if rerr, ok := data.(*RecordError); ok {
+			if err := e.skipRecord(ctx, rerr); err != nil {
+				e.errQueue.Append(err)
+				return
+			}
+			// A skipped record is finished once read
+			e.progress.addFinished()
+			continue
+		}
+		if _, ok := data.(control); !ok {
+			e.items.count(ctx, data)
+		}
+
+		// Wait for the memory budget to have room for the data, unless
+		// a spill buffer admits the data once it leaves the buffer
+		if _, ok := e.spills[0]; !ok && !e.memory.admit(ctx, data) {
+			return
+		}
+
+		if c, ok := data.(control); ok {
+			if !sendAll(ctx, outs, c) {
+				return
+			}
+		} else {
+			select {
+			case outs[shard(data)] <- data:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if !e.injectBarrier(ctx, src, outs, false) {
 			return
 		}
 	}
 	// Check for errors
 	if err := src.Error(); err != nil {
-		errQueue.Append(fmt.Errorf("pipeline input source: %v", err))
+		e.errQueue.Append(fmt.Errorf("pipeline input source: %v", err))
+		return
+	}
+	// A source aware of the context also stops once the context expires
+	if ctx.Err() != nil {
+		return
+	}
+
+	atomic.StoreInt32(&e.exhausted, 1)
+	// Take the final checkpoint once the source has been exhausted
+	e.injectBarrier(ctx, src, outs, true)
+}
+
+// injectBarrier sends a barrier to the first stage when a checkpoint is due.
+// It returns false if the execution needs to stop.
+func (e *execution) injectBarrier(ctx context.Context, src InputSource, outs []chan Data, final bool) bool {
+	b, err := e.nextBarrier(src, final)
+	if err != nil {
+		e.errQueue.Append(fmt.Errorf("pipeline input source: %v", err))
+		return false
 	}
+	if b == nil {
+		return true
+	}
+	return sendAll(ctx, outs, b)
 }
 
-func outputSinkRunner(ctx context.Context, sink OutputSink, inCh <-chan Data, errQueue *queue.Queue) {
+// sendAll sends the control record to each of the channels.
+// It returns false if the context expired.
+func sendAll(ctx context.Context, outs []chan Data, c control) bool {
+	for _, ch := range outs {
+		select {
+		case ch <- c:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// skipRecord sends the RecordError to the dead-letter sink and checks the
+// number of skipped records against the configured limit and error budget.
+func (e *execution) skipRecord(ctx context.Context, rerr *RecordError) error {
+	if e.deadLetter != nil {
+		if err := e.deadLetter.Consume(ctx, rerr); err != nil {
+			return fmt.Errorf("pipeline dead-letter sink: %v", err)
+		}
+	}
+	rerr.MarkAsProcessed()
+
+	e.skipped++
+	if e.maxSkipped >= 0 && e.skipped > e.maxSkipped {
+		return fmt.Errorf("pipeline input source: %w: %d records skipped, last error: %v", ErrSkipLimit, e.skipped, rerr.Err)
+	}
+	return e.budget.tolerate(rerr)
+}
+
+func (e *execution) outputSinkRunner(ctx context.Context, sink OutputSink, inCh <-chan Data) {
+	defer e.abortTxn()
+
 	for {
 		select {
 		case data, ok := <-inCh:
 			if !ok {
+				// Stages also close their output when the context expires
+				if ctx.Err() == nil {
+					atomic.AddInt32(&e.drained, 1)
+				}
 				return
 			}
 
-			if err := sink.Consume(ctx, data); err != nil {
-				errQueue.Append(fmt.Errorf("pipeline output sink: %v", err))
-				return
+			if c, ok := data.(control); ok {
+				if err := c.atSink(ctx); err != nil {
+					e.errQueue.Append(err)
+					return
+				}
+				continue
+			}
+
+			var handed bool
+			ictx := e.items.context(ctx, data)
+			switch {
+			case itemCancelled(ctx, ictx):
+			case e.items.deliver(data):
+				// The Future of the request takes over the Data instead of the OutputSink
+				handed = true
+			default:
+				err := sink.Consume(ictx, data)
+				if err != nil && !itemCancelled(ctx, ictx) {
+					e.errQueue.Append(fmt.Errorf("pipeline output sink: %v", err))
+					return
+				}
+				// A sink handing the Data over leaves it to the receiver
+				h, ok := sink.(handOverSink)
+				handed = ok && h.handsOver() && err == nil
+			}
+			if !handed {
+				data.MarkAsProcessed()
 			}
-			data.MarkAsProcessed()
+			e.memory.release(data)
+			e.items.done(data)
+			e.progress.addConsumed()
 		case <-ctx.Done():
 			return
 		}
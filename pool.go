@@ -2,12 +2,12 @@ package pipeline
 
 import (
 	"context"
-	"fmt"
 	"sync"
 )
 
 type fixedPool struct {
-	fifos []Stage
+	task Task
+	num  int
 }
 
 // FixedPool returns a Stage that spins up a pool containing numWorkers
@@ -17,25 +17,25 @@ func FixedPool(task Task, num int) Stage {
 		return nil
 	}
 
-	fifos := make([]Stage, num)
-	for i := 0; i < num; i++ {
-		fifos[i] = FIFO(task)
-	}
-
-	return &fixedPool{fifos: fifos}
+	return &fixedPool{task: task, num: num}
 }
 
+func (p *fixedPool) taskList() []Task { return []Task{p.task} }
+
 // Run implements Stage.
 func (p *fixedPool) Run(ctx context.Context, params StageParams) {
 	var wg sync.WaitGroup
+	// The workers share the gate to align on control records
+	g := new(gate)
+	task := chainTask(params, p.task)
 
 	// Spin up each task in the pool and wait for them to exit
-	for i := 0; i < len(p.fifos); i++ {
+	for i := 0; i < p.num; i++ {
 		wg.Add(1)
-		go func(idx int) {
-			p.fifos[idx].Run(ctx, params)
+		go func() {
+			runFIFO(ctx, params, task, g)
 			wg.Done()
-		}(i)
+		}()
 	}
 
 	wg.Wait()
@@ -62,8 +62,11 @@ func DynamicPool(task Task, max int) Stage {
 	return &dynamicPool{task: task, tokenPool: tokenPool}
 }
 
+func (p *dynamicPool) taskList() []Task { return []Task{p.task} }
+
 // Run implements Stage.
 func (p *dynamicPool) Run(ctx context.Context, sp StageParams) {
+	task := chainTask(sp, p.task)
 loop:
 	for {
 		select {
@@ -74,6 +77,17 @@ loop:
 				break loop
 			}
 
+			if c, ok := dataIn.(control); ok {
+				// Wait for the workers to emit all previous data
+				p.drainTokens()
+				ok = punctuate(ctx, sp, task, c) && forwardControl(ctx, sp, c)
+				p.fillTokens()
+				if !ok {
+					break loop
+				}
+				continue
+			}
+
 			var token struct{}
 			select {
 			case token = <-p.tokenPool:
@@ -83,30 +97,27 @@ loop:
 
 			go func(dataIn Data, token struct{}) {
 				defer func() { p.tokenPool <- token }()
-				dataOut, err := p.task.Process(ctx, dataIn)
-				if err != nil {
-					sp.Error().Append(fmt.Errorf("pipeline stage %d: %v", sp.Position(), err))
-					return
-				}
-
-				// If the task did not output data for the
-				// next stage there is nothing we need to do.
-				if dataOut == nil {
-					dataIn.MarkAsProcessed()
-					return
-				}
-
-				// Output processed data
-				select {
-				case sp.Output() <- dataOut:
-				case <-ctx.Done():
-				}
+				processFIFO(ctx, sp, task, dataIn)
 			}(dataIn, token)
 		}
 	}
 
 	// Wait for all workers to exit by trying to empty the token pool
+	p.drainTokens()
+	// Return the tokens so the stage can be executed again
+	p.fillTokens()
+}
+
+// drainTokens waits for all the workers to exit by emptying the token pool.
+func (p *dynamicPool) drainTokens() {
 	for i := 0; i < cap(p.tokenPool); i++ {
 		<-p.tokenPool
 	}
 }
+
+// fillTokens returns all the tokens to the pool.
+func (p *dynamicPool) fillTokens() {
+	for i := 0; i < cap(p.tokenPool); i++ {
+		p.tokenPool <- struct{}{}
+	}
+}
@@ -0,0 +1,133 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// DefaultPreflightTimeout is the time allowed for the preflight checks
+// when the PreflightTimeout option has not been provided.
+const DefaultPreflightTimeout = 30 * time.Second
+
+// ErrPreflight is matched by the errors returned when the preflight checks fail.
+var ErrPreflight = errors.New("preflight check failed")
+
+// Preflighter is implemented by Tasks, Stages, InputSources and OutputSinks
+// that can verify their dependencies are available before the pipeline pulls
+// any data. The pipeline calls Preflight for all of them concurrently and
+// refuses to start the execution if any of the checks fail.
+type Preflighter interface {
+	Preflight(context.Context) error
+}
+
+// taskStage is implemented by the Stages of this package that execute Tasks.
+type taskStage interface {
+	Stage
+	// taskList returns the Tasks executed by the stage.
+	taskList() []Task
+}
+
+// PreflightTimeout returns an Option that sets the time allowed for the preflight checks.
+func PreflightTimeout(d time.Duration) Option {
+	return func(p *Pipeline) {
+		p.preflightTimeout = d
+	}
+}
+
+// DryRun only performs the preflight checks for the InputSource, the
+// Stage instances, their Tasks, and the OutputSink. All check failures
+// are returned and no data is read from the InputSource.
+func (p *Pipeline) DryRun(ctx context.Context, src InputSource, sink OutputSink) error {
+	return p.preflight(ctx, src, sink)
+}
+
+func (p *Pipeline) preflight(ctx context.Context, src InputSource, sinks ...OutputSink) error {
+	checks := make(map[string]Preflighter)
+
+	if pf, ok := src.(Preflighter); ok {
+		checks["input source"] = pf
+	}
+	for i, sink := range sinks {
+		pf, ok := sink.(Preflighter)
+		if !ok {
+			continue
+		}
+
+		name := "output sink"
+		if len(sinks) > 1 {
+			name = fmt.Sprintf("output sink %d", i+1)
+		}
+		checks[name] = pf
+	}
+	for i, stage := range p.stages {
+		if pf, ok := stage.(Preflighter); ok {
+			checks[stageLabel(i+1, p.names[i])] = pf
+		}
+
+		ts, ok := stage.(taskStage)
+		if !ok {
+			continue
+		}
+		for j, task := range ts.taskList() {
+			if pf, ok := task.(Preflighter); ok {
+				checks[fmt.Sprintf("%s task %d", stageLabel(i+1, p.names[i]), j+1)] = pf
+			}
+		}
+	}
+	if len(checks) == 0 {
+		return nil
+	}
+
+	timeout := p.preflightTimeout
+	if timeout <= 0 {
+		timeout = DefaultPreflightTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type result struct {
+		name string
+		err  error
+	}
+	// The channel is buffered so that checks ignoring the context can return later
+	results := make(chan result, len(checks))
+	for name, pf := range checks {
+		go func(name string, pf Preflighter) {
+			results <- result{name: name, err: pf.Preflight(ctx)}
+		}(name, pf)
+	}
+
+	errs := make(map[string]error, len(checks))
+	for len(errs) < len(checks) {
+		select {
+		case r := <-results:
+			errs[r.name] = r.err
+		case <-ctx.Done():
+			// Report the checks still running as timed out
+			for name := range checks {
+				if _, ok := errs[name]; !ok {
+					errs[name] = ctx.Err()
+				}
+			}
+		}
+	}
+
+	names := make([]string, 0, len(errs))
+	for name := range errs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var err error
+	for _, name := range names {
+		if e := errs[name]; e != nil {
+			err = multierror.Append(err, fmt.Errorf("pipeline preflight %s: %w: %v", name, ErrPreflight, e))
+		}
+	}
+	return err
+}
@@ -0,0 +1,117 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestPreflightFailure(t *testing.T) {
+	src := &sourceStub{data: stringDataValues(3)}
+	sink := new(sinkStub)
+
+	p := NewPipeline(
+		FIFO(makePassthroughTask()),
+		Broadcast(makePassthroughTask(), &preflightTask{err: errors.New("unreachable")}),
+	)
+	err := p.Execute(context.TODO(), src, sink)
+	if !errors.Is(err, ErrPreflight) || OutcomeOf(err) != Failed {
+		t.Errorf("Error did not match the expectation: %v", err)
+	}
+	re := regexp.MustCompile("(?s).*pipeline preflight stage 2 task 2: .*unreachable.*")
+	if err == nil || !re.MatchString(err.Error()) {
+		t.Errorf("Error did not match the expectation: %v", err)
+	}
+	if src.index != 0 {
+		t.Errorf("Expected no data to be pulled from the source")
+	}
+}
+
+func TestPreflightTimeout(t *testing.T) {
+	task := &preflightTask{delay: time.Minute}
+
+	p := NewPipeline(FIFO(task)).With(PreflightTimeout(50 * time.Millisecond))
+	if err := p.DryRun(context.TODO(), new(sourceStub), new(sinkStub)); !errors.Is(err, ErrPreflight) {
+		t.Errorf("Error did not match the expectation: %v", err)
+	}
+}
+
+func TestPreflightIgnoringContext(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	stuck := &stuckTask{release: release}
+
+	p := NewPipeline(FIFO(stuck), FIFO(&preflightTask{err: errors.New("unreachable")}), FIFO(stuck))
+	p.With(PreflightTimeout(50 * time.Millisecond))
+
+	done := make(chan error, 1)
+	go func() { done <- p.DryRun(context.TODO(), new(sourceStub), new(sinkStub)) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("The preflight checks did not return after the timeout")
+	}
+	if !errors.Is(err, ErrPreflight) {
+		t.Errorf("Error did not match the expectation: %v", err)
+	}
+	// The checks are reported in the order of their names
+	re := regexp.MustCompile("(?s)stage 1 task 1: .*deadline exceeded.*stage 2 task 1: .*unreachable.*stage 3 task 1: .*deadline exceeded")
+	if err == nil || !re.MatchString(err.Error()) {
+		t.Errorf("Error did not match the expectation: %v", err)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	task := new(preflightTask)
+	src := &sourceStub{data: stringDataValues(3)}
+
+	p := NewPipeline(FixedPool(task, 3))
+	if err := p.DryRun(context.TODO(), src, new(sinkStub)); err != nil {
+		t.Errorf("Error performing the dry run: %v", err)
+	}
+	if !task.called {
+		t.Errorf("Expected the task preflight check to be called")
+	}
+	if src.index != 0 {
+		t.Errorf("Expected no data to be pulled from the source")
+	}
+}
+
+type preflightTask struct {
+	called bool
+	delay  time.Duration
+	err    error
+}
+
+func (t *preflightTask) Preflight(ctx context.Context) error {
+	t.called = true
+
+	select {
+	case <-time.After(t.delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return t.err
+}
+
+func (t *preflightTask) Process(_ context.Context, data Data) (Data, error) {
+	return data, nil
+}
+
+// stuckTask has a preflight check that ignores the context.
+type stuckTask struct {
+	release chan struct{}
+}
+
+func (t *stuckTask) Preflight(context.Context) error {
+	<-t.release
+	return nil
+}
+
+func (t *stuckTask) Process(_ context.Context, data Data) (Data, error) {
+	return data, nil
+}
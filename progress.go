@@ -0,0 +1,156 @@
+package pipeline
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// DefaultProgressInterval is the interval between progress reports when none is provided.
+const DefaultProgressInterval = time.Second
+
+// Lener is implemented by an InputSource that knows the total number of Data it provides.
+type Lener interface {
+	// Len returns the total number of Data provided by the InputSource.
+	Len() int
+}
+
+// Progress describes the progress of a pipeline execution.
+type Progress struct {
+	// Read is the number of Data read from the InputSource.
+	Read int64
+	// Consumed is the number of Data consumed by the OutputSink.
+	Consumed int64
+	// Dropped is the number of Data discarded by the stages.
+	Dropped int64
+	// Finished is the number of Data read from the InputSource that has been
+	// consumed, discarded, skipped or merged into a Batch, counted once along
+	// with the copies made by fan-out stages. Data implemented by non-pointer
+	// types cannot be followed through the pipeline and finishes once read.
+	Finished int64
+	// Total is the number of Data reported by an InputSource implementing
+	// Lener, or zero when the total is unknown.
+	Total int64
+	// Elapsed is the time since the execution started.
+	Elapsed time.Duration
+	// Rate is the average number of Data finished per second.
+	Rate float64
+	// ETA is the estimated time left to finish the remaining Data,
+	// or zero when the total is unknown.
+	ETA time.Duration
+	// Final is set for the report made once the execution has ended.
+	Final bool
+}
+
+// ProgressReporting returns an Option that has the pipeline report the progress
+// of each execution to the callback at the provided interval, and once more when
+// the execution ends. The callback is called from a single goroutine and must not
+// block for long.
+func ProgressReporting(interval time.Duration, report func(Progress)) Option {
+	return func(p *Pipeline) {
+		if interval <= 0 {
+			interval = DefaultProgressInterval
+		}
+		p.progressInterval = interval
+		p.progress = report
+	}
+}
+
+type progressTracker struct {
+	interval time.Duration
+	report   func(Progress)
+	total    int64
+	start    time.Time
+	// the counters are updated atomically
+	read     int64
+	consumed int64
+	dropped  int64
+	finished int64
+}
+
+func newProgressTracker(interval time.Duration, report func(Progress), src InputSource) *progressTracker {
+	if report == nil {
+		return nil
+	}
+
+	t := &progressTracker{
+		interval: interval,
+		report:   report,
+		start:    time.Now(),
+	}
+	if l, ok := src.(Lener); ok {
+		t.total = int64(l.Len())
+	}
+	return t
+}
+
+func (t *progressTracker) addRead() {
+	if t != nil {
+		atomic.AddInt64(&t.read, 1)
+	}
+}
+
+func (t *progressTracker) addConsumed() {
+	if t != nil {
+		atomic.AddInt64(&t.consumed, 1)
+	}
+}
+
+func (t *progressTracker) addDropped() {
+	if t != nil {
+		atomic.AddInt64(&t.dropped, 1)
+	}
+}
+
+func (t *progressTracker) addFinished() {
+	if t != nil {
+		atomic.AddInt64(&t.finished, 1)
+	}
+}
+
+// run reports the progress at each interval until done is closed, makes
+// the final report, and returns a channel closed once it has been made.
+func (t *progressTracker) run(done <-chan struct{}) <-chan struct{} {
+	reported := make(chan struct{})
+	if t == nil {
+		close(reported)
+		return reported
+	}
+
+	go func() {
+		defer close(reported)
+
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				t.report(t.progress(true))
+				return
+			case <-ticker.C:
+				t.report(t.progress(false))
+			}
+		}
+	}()
+	return reported
+}
+
+func (t *progressTracker) progress(final bool) Progress {
+	p := Progress{
+		Read:     atomic.LoadInt64(&t.read),
+		Consumed: atomic.LoadInt64(&t.consumed),
+		Dropped:  atomic.LoadInt64(&t.dropped),
+		Finished: atomic.LoadInt64(&t.finished),
+		Total:    t.total,
+		Elapsed:  time.Since(t.start),
+		Final:    final,
+	}
+
+	if secs := p.Elapsed.Seconds(); secs > 0 {
+		p.Rate = float64(p.Finished) / secs
+	}
+	if p.Total > p.Finished && p.Rate > 0 {
+		p.ETA = time.Duration(float64(p.Total-p.Finished) / p.Rate * float64(time.Second))
+	}
+	return p
+}
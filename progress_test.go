@@ -0,0 +1,125 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProgressReporting(t *testing.T) {
+	src := &sizedSource{sourceStub: sourceStub{data: stringDataValues(20)}}
+	task := TaskFunc(func(_ context.Context, d Data) (Data, error) {
+		time.Sleep(2 * time.Millisecond)
+		return d, nil
+	})
+
+	var lock sync.Mutex
+	var reports []Progress
+	p := NewPipeline(FIFO(task)).With(ProgressReporting(5*time.Millisecond, func(pr Progress) {
+		lock.Lock()
+		reports = append(reports, pr)
+		lock.Unlock()
+	}))
+	if err := p.Execute(context.TODO(), src, new(sinkStub)); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+
+	if len(reports) < 2 {
+		t.Fatalf("Expected periodic reports before the final report, got %d", len(reports))
+	}
+	for _, pr := range reports[:len(reports)-1] {
+		if pr.Final || pr.Total != 20 || pr.Read > 20 || pr.Consumed > pr.Read {
+			t.Errorf("Unexpected progress report: %+v", pr)
+		}
+		if pr.Finished > 0 && pr.Finished < 20 && (pr.Rate <= 0 || pr.ETA <= 0) {
+			t.Errorf("Expected a rate and an ETA: %+v", pr)
+		}
+	}
+	if last := reports[len(reports)-1]; !last.Final || last.Read != 20 || last.Consumed != 20 || last.ETA != 0 {
+		t.Errorf("Unexpected final progress report: %+v", last)
+	}
+}
+
+func TestProgressUnknownTotal(t *testing.T) {
+	var last Progress
+	p := NewPipeline(FIFO(makePassthroughTask())).With(ProgressReporting(0, func(pr Progress) { last = pr }))
+	if err := p.Execute(context.TODO(), &sourceStub{data: stringDataValues(5)}, new(sinkStub)); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+
+	if !last.Final || last.Total != 0 || last.Read != 5 || last.ETA != 0 {
+		t.Errorf("Unexpected final progress report: %+v", last)
+	}
+}
+
+func TestProgressDropped(t *testing.T) {
+	var count int
+	task := TaskFunc(func(_ context.Context, d Data) (Data, error) {
+		count++
+		if count%2 == 0 {
+			return nil, Drop("even")
+		}
+		return d, nil
+	})
+
+	var last Progress
+	src := &sizedSource{sourceStub: sourceStub{data: stringDataValues(20)}}
+	p := NewPipeline(FIFO(task)).With(ProgressReporting(0, func(pr Progress) { last = pr }))
+	if err := p.Execute(context.TODO(), src, new(sinkStub)); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+
+	if !last.Final || last.Consumed != 10 || last.Dropped != 10 || last.Finished != 20 || last.Rate <= 0 || last.ETA != 0 {
+		t.Errorf("Unexpected final progress report: %+v", last)
+	}
+}
+
+func TestProgressFinished(t *testing.T) {
+	skipped := stringDataValues(20)
+	for i := 0; i < len(skipped); i += 4 {
+		skipped[i] = Skip(skipped[i], errors.New("malformed"))
+	}
+
+	tests := map[string]struct {
+		stage    Stage
+		data     []Data
+		consumed int64
+	}{
+		"fan-out":  {Broadcast(makePassthroughTask(), makePassthroughTask()), stringDataValues(20), 40},
+		"batching": {FixedBatch(makePassthroughTask(), 5, time.Second), stringDataValues(20), 4},
+		"skipped":  {FIFO(makePassthroughTask()), skipped, 15},
+	}
+	for name, test := range tests {
+		var lock sync.Mutex
+		var reports []Progress
+		src := &sizedSource{sourceStub: sourceStub{data: test.data}}
+
+		p := NewPipeline(test.stage).With(ProgressReporting(time.Millisecond, func(pr Progress) {
+			lock.Lock()
+			reports = append(reports, pr)
+			lock.Unlock()
+		}))
+		if err := p.Execute(context.TODO(), src, new(sinkStub)); err != nil {
+			t.Errorf("%s: error executing the Pipeline: %v", name, err)
+		}
+
+		for _, pr := range reports {
+			if pr.Finished > pr.Read {
+				t.Errorf("%s: more data finished than read: %+v", name, pr)
+			}
+		}
+		last := reports[len(reports)-1]
+		if !last.Final || last.Finished != 20 || last.Consumed != test.consumed || last.ETA != 0 {
+			t.Errorf("%s: unexpected final progress report: %+v", name, last)
+		}
+	}
+}
+
+// sizedSource is a sourceStub reporting its total.
+type sizedSource struct {
+	sourceStub
+}
+
+func (s *sizedSource) Len() int { return len(s.data) }
@@ -0,0 +1,72 @@
+package pipeline
+
+import "context"
+
+// Punctuation marks a logical boundary in the flow of Data. An InputSource
+// emits a Punctuation by returning it from the Data method. Each stage delivers
+// the Punctuation to its Tasks once all the Data received before it has been
+// processed, and the Punctuation never reaches the OutputSink.
+type Punctuation struct {
+	// Label identifies the boundary, such as the end of a file or time window.
+	Label string
+}
+
+// Clone implements the pipeline Data interface.
+func (p *Punctuation) Clone() Data { return p }
+
+// MarkAsProcessed implements the pipeline Data interface.
+func (p *Punctuation) MarkAsProcessed() {}
+
+// String implements the Stringer interface.
+func (p *Punctuation) String() string { return "punctuation " + p.Label }
+
+func (p *Punctuation) atStage(context.Context, int) {}
+
+func (p *Punctuation) atSink(context.Context) error { return nil }
+
+// Punctuator is implemented by Tasks that need to learn when all the Data
+// before a Punctuation has arrived, for example to flush a batch.
+type Punctuator interface {
+	// Punctuate is called once all the Data received before the Punctuation
+	// has been processed by the Task. The returned Data, if not nil, is emitted
+	// to the next stage ahead of the Punctuation.
+	Punctuate(context.Context, *Punctuation) (Data, error)
+}
+
+// punctuate delivers the control record to the task when it is a Punctuation
+// and emits the returned Data. It returns false if the task failed or the
+// context expired, in which case the control record is not forwarded.
+func punctuate(ctx context.Context, sp StageParams, task Task, c control) bool {
+	dataOut, err := punctuateTask(ctx, task, c)
+	if err != nil {
+		sp.Error().Append(stageError(sp, sp.Position(), err))
+		abandonControl(sp)
+		return false
+	}
+	if dataOut == nil {
+		return true
+	}
+
+	memoryOf(sp).charge(dataOut)
+	select {
+	case <-ctx.Done():
+		abandonControl(sp)
+		return false
+	case sp.Output() <- dataOut:
+	}
+	return true
+}
+
+// punctuateTask calls the Punctuator implemented by the task, if any.
+func punctuateTask(ctx context.Context, task Task, c control) (Data, error) {
+	pn, ok := c.(*Punctuation)
+	if !ok {
+		return nil, nil
+	}
+
+	pt, ok := task.(Punctuator)
+	if !ok {
+		return nil, nil
+	}
+	return pt.Punctuate(ctx, pn)
+}
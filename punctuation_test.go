@@ -0,0 +1,116 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPunctuation(t *testing.T) {
+	stages := map[string]func(Task) []Stage{
+		"FIFO":        func(task Task) []Stage { return []Stage{FIFO(task)} },
+		"FixedPool":   func(task Task) []Stage { return []Stage{FixedPool(task, 3)} },
+		"DynamicPool": func(task Task) []Stage { return []Stage{DynamicPool(task, 3)} },
+		"Broadcast":   func(task Task) []Stage { return []Stage{Broadcast(task)} },
+		"Fused": func(task Task) []Stage {
+			return []Stage{FIFO(makePassthroughTask()), FIFO(task), FIFO(makePassthroughTask())}
+		},
+	}
+
+	for name, makeStages := range stages {
+		src := &sourceStub{data: makePunctuatedValues(9, 3)}
+		sink := new(sinkStub)
+
+		p := NewPipeline(makeStages(new(batchTask))...).With(FuseStages())
+		if err := p.Execute(context.TODO(), src, sink); err != nil {
+			t.Errorf("%s: Error executing the Pipeline: %v", name, err)
+		}
+
+		want := []string{"0,1,2", "3,4,5", "6,7,8"}
+		var got []string
+		for _, d := range sink.data {
+			got = append(got, d.(*stringData).val)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Batches do not match.\nWanted:%v\nGot:%v\n", name, want, got)
+		}
+	}
+}
+
+func TestParallelPunctuation(t *testing.T) {
+	src := &sourceStub{data: makePunctuatedValues(3, 3)}
+	sink := new(sinkStub)
+	batch := new(batchTask)
+
+	p := NewPipeline(Parallel(batch, makePassthroughTask()))
+	if err := p.Execute(context.TODO(), src, sink); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+	if len(sink.data) != 0 || batch.punctuated != 1 {
+		t.Errorf("Expected the punctuation to only reach the tasks")
+	}
+}
+
+func TestBroadcastPunctuationError(t *testing.T) {
+	src := &sourceStub{data: makePunctuatedValues(3, 3)}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// The failing task receives the punctuation last
+	p := NewPipeline(Broadcast(makePassthroughTask(), failingPunctuator{}))
+	if err := p.Execute(ctx, src, new(sinkStub)); err == nil || errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the punctuation error, got %v", err)
+	}
+}
+
+// makePunctuatedValues returns num values with a Punctuation following every nth value.
+func makePunctuatedValues(num, nth int) []Data {
+	var data []Data
+	for i, d := range stringDataValues(num) {
+		data = append(data, d)
+		if (i+1)%nth == 0 {
+			data = append(data, &Punctuation{Label: d.(*stringData).val})
+		}
+	}
+	return data
+}
+
+// batchTask holds the data until a Punctuation arrives and then emits them as one value.
+type batchTask struct {
+	sync.Mutex
+	vals       []string
+	punctuated int
+}
+
+func (b *batchTask) Process(_ context.Context, d Data) (Data, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.vals = append(b.vals, d.(*stringData).val)
+	return nil, nil
+}
+
+func (b *batchTask) Punctuate(_ context.Context, _ *Punctuation) (Data, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.punctuated++
+	sort.Strings(b.vals)
+	d := &stringData{val: strings.Join(b.vals, ",")}
+	b.vals = nil
+	return d, nil
+}
+
+// failingPunctuator passes the data through and fails at each Punctuation.
+type failingPunctuator struct{}
+
+func (failingPunctuator) Process(_ context.Context, d Data) (Data, error) { return d, nil }
+
+func (failingPunctuator) Punctuate(context.Context, *Punctuation) (Data, error) {
+	return nil, errors.New("punctuation error")
+}
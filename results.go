@@ -0,0 +1,122 @@
+package pipeline
+
+import "context"
+
+// Collect executes the pipeline with an OutputSink that gathers the Data in
+// the order it is consumed, and returns it once the execution has ended. The
+// Data gathered before an unsuccessful execution ended is returned with the error.
+func (p *Pipeline) Collect(ctx context.Context, src InputSource) ([]Data, error) {
+	var results []Data
+
+	err := p.Execute(ctx, src, SinkFunc(func(_ context.Context, data Data) error {
+		results = append(results, data)
+		return nil
+	}))
+	return results, err
+}
+
+// Stream is an iterator over the Data emitted by a pipeline execution. The
+// execution only proceeds as fast as the Data is read from the Stream. A Stream
+// implements InputSource, so it can feed another pipeline.
+type Stream struct {
+	cancel context.CancelFunc
+	ch     chan Data
+	data   Data
+	done   chan struct{}
+	err    error
+}
+
+// Stream starts executing the pipeline and returns the Stream providing the
+// Data emitted by the execution. The Stream must be read until Next returns
+// false, or closed, for the execution to end. The Data is handed over to the
+// reader, so the pipeline does not invoke MarkAsProcessed for it.
+func (p *Pipeline) Stream(ctx context.Context, src InputSource) *Stream {
+	return p.stream(ctx, func(ctx context.Context, sink OutputSink) error {
+		return p.Execute(ctx, src, sink)
+	})
+}
+
+// stream runs the execution with the OutputSink sending the Data to the returned Stream.
+func (p *Pipeline) stream(ctx context.Context, execute func(context.Context, OutputSink) error) *Stream {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Stream{
+		cancel: cancel,
+		ch:     make(chan Data),
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(s.done)
+		defer cancel()
+
+		s.err = execute(ctx, &streamSink{ctx: ctx, ch: s.ch})
+	}()
+	return s
+}
+
+// streamSink hands the Data over to the reader of a Stream.
+type streamSink struct {
+	ctx context.Context
+	ch  chan<- Data
+}
+
+// handOverSink is implemented by an OutputSink that hands the consumed Data over
+// to a receiver, which marks the Data as processed instead of the pipeline.
+type handOverSink interface {
+	handsOver() bool
+}
+
+// handsOver implements the handOverSink interface, as the reader of a Stream
+// takes over the Data it received.
+func (s *streamSink) handsOver() bool { return true }
+
+// Consume implements the OutputSink interface.
+func (s *streamSink) Consume(ctx context.Context, data Data) error {
+	select {
+	case s.ch <- data:
+		return nil
+	case <-ctx.Done():
+	}
+
+	// The outcome of the execution reports its cancellation
+	if s.ctx.Err() != nil {
+		return nil
+	}
+	return ctx.Err()
+}
+
+// Next waits for the next Data emitted by the execution. It returns false once
+// the execution has ended or the context expires.
+func (s *Stream) Next(ctx context.Context) bool {
+	select {
+	case data := <-s.ch:
+		s.data = data
+		return true
+	case <-s.done:
+	case <-ctx.Done():
+	}
+
+	s.data = nil
+	return false
+}
+
+// Data returns the Data obtained by the last call to Next.
+func (s *Stream) Data() Data { return s.data }
+
+// Error returns the error of the execution once it has ended. The error
+// is nil while the execution is still running.
+func (s *Stream) Error() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+	}
+	return nil
+}
+
+// Close cancels the execution if it is still running and waits for it to end.
+func (s *Stream) Close() error {
+	s.cancel()
+	<-s.done
+	return s.err
+}
@@ -0,0 +1,65 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCollect(t *testing.T) {
+	values := stringDataValues(5)
+
+	got, err := NewPipeline(FIFO(makePassthroughTask())).Collect(context.TODO(), &sourceStub{data: values})
+	if err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+	if !reflect.DeepEqual(got, values) {
+		t.Errorf("Data does not match.\nWanted:%v\nGot:%v\n", values, got)
+	}
+	assertAllProcessed(t, values)
+}
+
+func TestStream(t *testing.T) {
+	values := stringDataValues(10)
+
+	s := NewPipeline(FIFO(makePassthroughTask())).Stream(context.TODO(), &sourceStub{data: values})
+	var got []Data
+	for s.Next(context.TODO()) {
+		got = append(got, s.Data())
+	}
+	if err := s.Error(); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+	if !reflect.DeepEqual(got, values) {
+		t.Errorf("Data does not match.\nWanted:%v\nGot:%v\n", values, got)
+	}
+}
+
+func TestStreamClose(t *testing.T) {
+	s := NewPipeline(FIFO(makePassthroughTask())).Stream(context.TODO(), &sourceStub{data: stringDataValues(10)})
+	if !s.Next(context.TODO()) {
+		t.Fatalf("Expected data from the Stream")
+	}
+
+	// The execution waits for the reader until the Stream is closed
+	if err := s.Close(); OutcomeOf(err) != Cancelled || !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the execution to be cancelled, got %v", err)
+	}
+	if s.Next(context.TODO()) {
+		t.Errorf("Expected no more data once the Stream was closed")
+	}
+}
+
+func TestStreamChaining(t *testing.T) {
+	values := stringDataValues(5)
+
+	s := NewPipeline(FIFO(makePassthroughTask())).Stream(context.TODO(), &sourceStub{data: values})
+	sink := new(sinkStub)
+	if err := NewPipeline(FIFO(makePassthroughTask())).Execute(context.TODO(), s, sink); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+	if !reflect.DeepEqual(sink.data, values) {
+		t.Errorf("Data does not match.\nWanted:%v\nGot:%v\n", values, sink.data)
+	}
+}
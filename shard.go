@@ -0,0 +1,112 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+)
+
+// ExecuteSharded runs n independent copies of the pipeline Stage instances, each
+// with its own channels, and partitions the data from the InputSource across
+// them by the key returned for each Data. All Data with the same key is processed
+// by the same shard. The outputs of the shards are merged into a single OutputSink,
+// or each shard has its own OutputSink when n sinks are provided. Control records
+// are sent through every shard.
+//
+// The Stage instances of this package are copied for each shard, while the Tasks
+// and other Stage implementations are shared and must be safe for concurrent use.
+// Checkpointing is not supported by sharded executions.
+func (p *Pipeline) ExecuteSharded(ctx context.Context, src InputSource, n int, key func(Data) string, sinks ...OutputSink) error {
+	var err error
+
+	switch {
+	case n <= 0:
+		err = fmt.Errorf("pipeline sharded execution: %d shards requested", n)
+	case key == nil:
+		err = fmt.Errorf("pipeline sharded execution: no key function provided")
+	case len(sinks) != 1 && len(sinks) != n:
+		err = fmt.Errorf("pipeline sharded execution: %d output sinks provided for %d shards", len(sinks), n)
+	case p.checkpoints != nil && n > 1:
+		err = fmt.Errorf("pipeline sharded execution: checkpointing is not supported")
+	}
+	if err != nil {
+		return &ExecutionError{Outcome: Failed, Cause: err}
+	}
+
+	return p.execute(ctx, src, sinks, n, key, 1)
+}
+
+// shardFunc returns the function selecting the shard for each Data.
+func shardFunc(shards int, key func(Data) string) func(Data) int {
+	if shards == 1 {
+		return func(Data) int { return 0 }
+	}
+
+	return func(data Data) int {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(key(data)))
+		return int(h.Sum32() % uint32(shards))
+	}
+}
+
+// cloneStages returns copies of the Stage instances that can run alongside the originals.
+func cloneStages(stages []Stage) []Stage {
+	out := make([]Stage, len(stages))
+
+	for i, stage := range stages {
+		out[i] = cloneStage(stage)
+	}
+	return out
+}
+
+// cloneStage copies the Stage instances of this package that keep state while
+// running. Other Stage implementations are shared.
+func cloneStage(stage Stage) Stage {
+	switch s := stage.(type) {
+	case *dynamicPool:
+		return DynamicPool(s.task, cap(s.tokenPool))
+	case *batcher:
+		if s.adaptive {
+			return AdaptiveBatch(s.task, s.target)
+		}
+		return FixedBatch(s.task, s.size, s.wait)
+	case *fairPool:
+		return FairPool(s.task, s.workers, s.tenant, s.weights)
+	case *speculative:
+		return &speculative{Stage: cloneStage(s.Stage), task: s.task}
+	case *intercepted:
+		return &intercepted{stage: cloneStage(s.stage), middleware: s.middleware}
+	}
+	return stage
+}
+
+// mergeOutputs forwards the data from each of the channels to the returned
+// channel, which is closed once all the channels have been closed.
+func mergeOutputs(ctx context.Context, wg *sync.WaitGroup, ins []<-chan Data, bufsize int) <-chan Data {
+	out := make(chan Data, bufsize)
+
+	var merged sync.WaitGroup
+	for _, in := range ins {
+		merged.Add(1)
+		go func(in <-chan Data) {
+			defer merged.Done()
+
+			for data := range in {
+				select {
+				case out <- data:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(in)
+	}
+
+	wg.Add(1)
+	go func() {
+		merged.Wait()
+		close(out)
+		wg.Done()
+	}()
+	return out
+}
@@ -0,0 +1,130 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestExecuteSharded(t *testing.T) {
+	rec := &shardRecorder{shards: make(map[string]StageParams)}
+	p := NewPipeline(DynamicPool(makePassthroughTask(), 2), rec, FixedBatch(makePassthroughTask(), 1, 0))
+
+	src := &sourceStub{data: stringDataValues(100)}
+	sink := new(sinkStub)
+
+	if err := p.ExecuteSharded(context.TODO(), src, 4, keyOf, sink); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+	if len(sink.data) != len(src.data) {
+		t.Errorf("Expected %d data to reach the sink, got %d", len(src.data), len(sink.data))
+	}
+	if err := rec.err(); err != nil {
+		t.Error(err)
+	}
+	if n := rec.count(); n != 4 {
+		t.Errorf("Expected the data to be processed by 4 shards, got %d", n)
+	}
+	assertAllProcessed(t, src.data)
+}
+
+func TestExecuteShardedSinks(t *testing.T) {
+	src := &sourceStub{data: stringDataValues(100)}
+	sinks := []OutputSink{new(sinkStub), new(sinkStub), new(sinkStub)}
+
+	p := NewPipeline(FIFO(makePassthroughTask()))
+	if err := p.ExecuteSharded(context.TODO(), src, 3, keyOf, sinks...); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+
+	var total int
+	seen := make(map[string]int)
+	for i, sink := range sinks {
+		data := sink.(*sinkStub).data
+		if len(data) == 0 {
+			t.Errorf("Expected output sink %d to receive data", i)
+		}
+
+		total += len(data)
+		for _, d := range data {
+			k := keyOf(d)
+			if j, ok := seen[k]; ok && j != i {
+				t.Errorf("Key %s reached output sinks %d and %d", k, j, i)
+			}
+			seen[k] = i
+		}
+	}
+	if total != len(src.data) {
+		t.Errorf("Expected %d data to reach the sinks, got %d", len(src.data), total)
+	}
+}
+
+func TestExecuteShardedSinkCount(t *testing.T) {
+	p := NewPipeline(FIFO(makePassthroughTask()))
+
+	err := p.ExecuteSharded(context.TODO(), &sourceStub{}, 3, keyOf, new(sinkStub), new(sinkStub))
+	if OutcomeOf(err) != Failed {
+		t.Errorf("Expected the execution to fail for 2 output sinks and 3 shards, got %v", err)
+	}
+}
+
+// keyOf returns one of ten keys for the Data.
+func keyOf(d Data) string {
+	var n int
+	fmt.Sscanf(d.(*stringData).val, "%d", &n)
+	return fmt.Sprint(n % 10)
+}
+
+// shardRecorder passes the data through and records the
+// StageParams of the shard that processed each key.
+type shardRecorder struct {
+	sync.Mutex
+	shards map[string]StageParams
+	failed error
+}
+
+func (s *shardRecorder) Run(ctx context.Context, sp StageParams) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case d, ok := <-sp.Input():
+			if !ok {
+				return
+			}
+
+			s.Lock()
+			k := keyOf(d)
+			if prev, ok := s.shards[k]; ok && prev != sp {
+				s.failed = fmt.Errorf("Key %s was processed by more than one shard", k)
+			}
+			s.shards[k] = sp
+			s.Unlock()
+
+			select {
+			case <-ctx.Done():
+				return
+			case sp.Output() <- d:
+			}
+		}
+	}
+}
+
+func (s *shardRecorder) err() error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.failed
+}
+
+func (s *shardRecorder) count() int {
+	s.Lock()
+	defer s.Unlock()
+
+	shards := make(map[StageParams]struct{})
+	for _, sp := range s.shards {
+		shards[sp] = struct{}{}
+	}
+	return len(shards)
+}
@@ -0,0 +1,74 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrSkip is matched by the errors reported for individual records that an
+// InputSource skipped without ending the pipeline execution.
+var ErrSkip = errors.New("record skipped")
+
+// ErrSkipLimit is returned when an InputSource skipped more records than
+// allowed by the MaxSkipped option.
+var ErrSkipLimit = errors.New("skipped record limit exceeded")
+
+// RecordError reports a malformed record observed by an InputSource. A source
+// returns a RecordError from the Data method to have the pipeline skip the
+// record instead of aborting the execution. The pipeline counts the skipped
+// records and sends each RecordError to the dead-letter sink, if one is set.
+type RecordError struct {
+	// Data is the malformed record, which can be nil.
+	Data Data
+	// Err is the error observed while reading the record.
+	Err error
+}
+
+// Skip returns a RecordError for the provided record and error.
+func Skip(data Data, err error) *RecordError {
+	return &RecordError{Data: data, Err: err}
+}
+
+// Error implements the error interface.
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrSkip, e.Err)
+}
+
+// Unwrap returns the error observed while reading the record.
+func (e *RecordError) Unwrap() error { return e.Err }
+
+// Is reports whether target is ErrSkip.
+func (e *RecordError) Is(target error) bool { return target == ErrSkip }
+
+// Clone implements the pipeline Data interface.
+func (e *RecordError) Clone() Data {
+	var data Data
+	if e.Data != nil {
+		data = e.Data.Clone()
+	}
+	return &RecordError{Data: data, Err: e.Err}
+}
+
+// MarkAsProcessed implements the pipeline Data interface.
+func (e *RecordError) MarkAsProcessed() {
+	if e.Data != nil {
+		e.Data.MarkAsProcessed()
+	}
+}
+
+// DeadLetter returns an Option that sends each RecordError reported by the
+// InputSource to the provided sink.
+func DeadLetter(sink OutputSink) Option {
+	return func(p *Pipeline) {
+		p.deadLetter = sink
+	}
+}
+
+// MaxSkipped returns an Option that aborts the pipeline execution with
+// ErrSkipLimit once the InputSource has skipped more than max records.
+// Without this option, skipped records never abort the execution.
+func MaxSkipped(max int) Option {
+	return func(p *Pipeline) {
+		p.maxSkipped = max
+	}
+}
@@ -0,0 +1,46 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSkippedRecords(t *testing.T) {
+	values := stringDataValues(3)
+	bad := &stringData{val: "bad"}
+	src := &sourceStub{data: []Data{values[0], Skip(bad, errors.New("malformed")), values[1], values[2]}}
+	sink := new(sinkStub)
+	dead := new(sinkStub)
+
+	p := NewPipeline(FIFO(makePassthroughTask())).With(DeadLetter(dead))
+	if err := p.Execute(context.TODO(), src, sink); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+	if !reflect.DeepEqual(sink.data, values) {
+		t.Errorf("Data does not match.\nWanted:%v\nGot:%v\n", values, sink.data)
+	}
+	if len(dead.data) != 1 {
+		t.Fatalf("Expected 1 record in the dead-letter sink, got %d", len(dead.data))
+	}
+	if rerr, ok := dead.data[0].(*RecordError); !ok || rerr.Data != bad || !errors.Is(rerr, ErrSkip) {
+		t.Errorf("Dead-letter sink received an unexpected record: %v", dead.data[0])
+	}
+
+	assertAllProcessed(t, append(values, bad))
+}
+
+func TestSkipLimit(t *testing.T) {
+	var data []Data
+	for i := 0; i < 5; i++ {
+		data = append(data, Skip(nil, errors.New("malformed")))
+	}
+	src := &sourceStub{data: data}
+	sink := new(sinkStub)
+
+	p := NewPipeline(FIFO(makePassthroughTask())).With(MaxSkipped(2))
+	if err := p.Execute(context.TODO(), src, sink); !errors.Is(err, ErrSkipLimit) {
+		t.Errorf("Error did not match the expectation: %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// minSpeculativeSamples is the number of latency samples
+// required before duplicate attempts are launched.
+const minSpeculativeSamples = 20
+
+// Idempotent is implemented by Tasks that can safely process the same Data more than once.
+type Idempotent interface {
+	// Idempotent returns true if duplicate attempts are allowed for the Task.
+	Idempotent() bool
+}
+
+type speculative struct {
+	Stage
+	task *speculativeTask
+}
+
+// Speculative returns the FixedPool or DynamicPool Stage with speculative execution
+// of straggling items. Once the processing time of an item passes the percentile
+// of the recent processing times, a duplicate attempt is launched on a Clone of
+// the Data. The output of the attempt finishing first without an error is used
+// and the context of the other attempt is cancelled, and its input is marked as
+// processed once the attempt has returned. Duplicate attempts are only
+// launched when the Task implements Idempotent and returns true. The Stage
+// implements MetricsReporter to expose the number of duplicate attempts.
+func Speculative(pool Stage, percentile float64) Stage {
+	if percentile <= 0 || percentile >= 1 {
+		return nil
+	}
+
+	wrap := func(task Task) *speculativeTask {
+		t := &speculativeTask{
+			task:       task,
+			percentile: percentile,
+			latency:    newLatencyWindow(latencySamples),
+		}
+		t.returned = sync.NewCond(&t.lock)
+		return t
+	}
+
+	switch p := pool.(type) {
+	case *fixedPool:
+		t := wrap(p.task)
+		return &speculative{Stage: &fixedPool{task: t, num: p.num}, task: t}
+	case *dynamicPool:
+		t := wrap(p.task)
+		return &speculative{Stage: DynamicPool(t, cap(p.tokenPool)), task: t}
+	}
+	return nil
+}
+
+func (s *speculative) taskList() []Task { return []Task{s.task.task} }
+
+// Run implements Stage.
+func (s *speculative) Run(ctx context.Context, sp StageParams) {
+	s.Stage.Run(ctx, sp)
+	// The stage ends once the losing attempts have returned
+	s.task.wait()
+}
+
+// Metrics implements the MetricsReporter interface.
+func (s *speculative) Metrics() map[string]float64 {
+	return map[string]float64{
+		"speculative_attempts":          float64(atomic.LoadInt64(&s.task.attempts)),
+		"speculative_wins":              float64(atomic.LoadInt64(&s.task.wins)),
+		"speculative_threshold_seconds": s.task.threshold().Seconds(),
+	}
+}
+
+// speculativeTask launches the duplicate attempts for the Task.
+type speculativeTask struct {
+	task       Task
+	percentile float64
+	latency    *latencyWindow
+	// attempts and wins count the duplicate attempts
+	// launched and the ones finishing first
+	attempts int64
+	wins     int64
+	// losing counts the losing attempts that have not returned yet
+	lock     sync.Mutex
+	losing   int
+	returned *sync.Cond
+}
+
+type attempt struct {
+	data      Data
+	err       error
+	elapsed   time.Duration
+	duplicate bool
+}
+
+// threshold returns the processing time after which a duplicate attempt
+// is launched, or zero while there are not enough samples.
+func (t *speculativeTask) threshold() time.Duration {
+	if t.latency.count() < minSpeculativeSamples {
+		return 0
+	}
+	return t.latency.percentile(t.percentile)
+}
+
+// Process implements the Task interface.
+func (t *speculativeTask) Process(ctx context.Context, data Data) (Data, error) {
+	if i, ok := t.task.(Idempotent); !ok || !i.Idempotent() {
+		return t.task.Process(ctx, data)
+	}
+
+	results := make(chan attempt, 2)
+	actx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go t.attempt(actx, data, false, results)
+
+	var timeout <-chan time.Time
+	if threshold := t.threshold(); threshold > 0 {
+		timeout = time.After(threshold)
+	}
+
+	select {
+	case r := <-results:
+		t.latency.add(r.elapsed)
+		return r.data, r.err
+	case <-timeout:
+	}
+
+	atomic.AddInt64(&t.attempts, 1)
+	dctx, dcancel := context.WithCancel(ctx)
+	defer dcancel()
+	clone := data.Clone()
+	go t.attempt(dctx, clone, true, results)
+
+	// Use the first attempt finishing without an error
+	var r attempt
+	running := 2
+	for running > 0 {
+		r = <-results
+		running--
+		if r.err == nil {
+			break
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	t.latency.add(r.elapsed)
+	lost := clone
+	if r.duplicate {
+		atomic.AddInt64(&t.wins, 1)
+		lost = data
+	}
+	// The input of the losing attempt is discarded once the attempt has returned
+	if running == 0 {
+		lost.MarkAsProcessed()
+	} else {
+		t.lose(results, lost)
+	}
+	return r.data, nil
+}
+
+// lose marks the input of the losing attempt as processed once the attempt has returned.
+func (t *speculativeTask) lose(results <-chan attempt, input Data) {
+	t.lock.Lock()
+	t.losing++
+	t.lock.Unlock()
+
+	go func() {
+		<-results
+		input.MarkAsProcessed()
+
+		t.lock.Lock()
+		t.losing--
+		t.returned.Broadcast()
+		t.lock.Unlock()
+	}()
+}
+
+// wait blocks until the losing attempts have returned.
+func (t *speculativeTask) wait() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for t.losing > 0 {
+		t.returned.Wait()
+	}
+}
+
+func (t *speculativeTask) attempt(ctx context.Context, data Data, duplicate bool, results chan<- attempt) {
+	start := time.Now()
+	out, err := t.task.Process(ctx, data)
+
+	results <- attempt{
+		data:      out,
+		err:       err,
+		elapsed:   time.Since(start),
+		duplicate: duplicate,
+	}
+}
+
+// Punctuate implements the Punctuator interface for the Task.
+func (t *speculativeTask) Punctuate(ctx context.Context, pn *Punctuation) (Data, error) {
+	return punctuateTask(ctx, t.task, pn)
+}
@@ -0,0 +1,99 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSpeculative(t *testing.T) {
+	task := &stragglerTask{idempotent: true, straggler: "40", cancelled: make(chan struct{})}
+	stage := Speculative(FixedPool(task, 4), 0.9)
+
+	src := &sourceStub{data: stringDataValues(50)}
+	sink := new(sinkStub)
+
+	start := time.Now()
+	if err := NewPipeline(stage).Execute(context.TODO(), src, sink); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the straggler to be replaced by a duplicate attempt, took %v", elapsed)
+	}
+	if len(sink.data) != len(src.data) {
+		t.Errorf("Expected %d data to reach the sink, got %d", len(src.data), len(sink.data))
+	}
+	// The stage waits for the cancelled attempt to return
+	select {
+	case <-task.cancelled:
+	default:
+		t.Errorf("Expected the straggling attempt to be cancelled")
+	}
+	assertAllProcessed(t, src.data)
+
+	metrics := stage.(MetricsReporter).Metrics()
+	if metrics["speculative_wins"] < 1 {
+		t.Errorf("Expected a duplicate attempt to finish first, got %v wins", metrics["speculative_wins"])
+	}
+}
+
+func TestSpeculativeNotIdempotent(t *testing.T) {
+	task := &stragglerTask{straggler: "40", delay: 100 * time.Millisecond, cancelled: make(chan struct{})}
+	stage := Speculative(DynamicPool(task, 4), 0.9)
+
+	src := &sourceStub{data: stringDataValues(50)}
+	if err := NewPipeline(stage).Execute(context.TODO(), src, new(sinkStub)); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+	if n := stage.(MetricsReporter).Metrics()["speculative_attempts"]; n != 0 {
+		t.Errorf("Expected no duplicate attempts for a Task that is not idempotent, got %v", n)
+	}
+	assertAllProcessed(t, src.data)
+}
+
+func TestSpeculativeRequiresPool(t *testing.T) {
+	if Speculative(FIFO(makePassthroughTask()), 0.9) != nil {
+		t.Errorf("Expected a nil Stage for a Stage that is not a pool")
+	}
+}
+
+// stragglerTask blocks on the first attempt for the straggler Data until the
+// delay has passed or the attempt is cancelled. A zero delay blocks until cancelled.
+type stragglerTask struct {
+	sync.Mutex
+	idempotent bool
+	straggler  string
+	delay      time.Duration
+	attempted  bool
+	// cancelled is closed once the first attempt has been cancelled
+	cancelled chan struct{}
+}
+
+func (s *stragglerTask) Idempotent() bool { return s.idempotent }
+
+func (s *stragglerTask) Process(ctx context.Context, d Data) (Data, error) {
+	s.Lock()
+	first := d.(*stringData).val == s.straggler && !s.attempted
+	if first {
+		s.attempted = true
+	}
+	s.Unlock()
+
+	if !first {
+		time.Sleep(time.Millisecond)
+		return d, nil
+	}
+
+	var timeout <-chan time.Time
+	if s.delay > 0 {
+		timeout = time.After(s.delay)
+	}
+
+	select {
+	case <-timeout:
+	case <-ctx.Done():
+		close(s.cancelled)
+	}
+	return d, ctx.Err()
+}
@@ -0,0 +1,456 @@
+package pipeline
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/caffix/queue"
+)
+
+// DefaultSegmentItems is the number of Data written to each segment file
+// when the Spill configuration does not specify it.
+const DefaultSegmentItems = 1024
+
+// Spill configures the buffer installed on a link by the SpillBuffer option.
+type Spill struct {
+	// Dir is where the segment files are created. The default
+	// directory for temporary files is used when empty.
+	Dir string
+
+	// Items is the number of Data kept in memory before the
+	// following Data is spilled to the segment files.
+	Items int
+
+	// SegmentItems is the number of Data written to each segment file.
+	SegmentItems int
+
+	// Codec encodes the spilled Data. The GobCodec is used when nil.
+	Codec Codec
+
+	// Compress has the segment files compressed with gzip.
+	Compress bool
+}
+
+// Codec creates the encoders and decoders for the Data spilled to the segment files.
+type Codec interface {
+	NewEncoder(io.Writer) Encoder
+	NewDecoder(io.Reader) Decoder
+}
+
+// Encoder writes Data to a segment file.
+type Encoder interface {
+	Encode(Data) error
+}
+
+// Decoder reads Data back from a segment file in the order it was written.
+type Decoder interface {
+	Decode() (Data, error)
+}
+
+// GobCodec is a Codec based on encoding/gob. The concrete Data types
+// must be registered with gob.
+type GobCodec struct{}
+
+// NewEncoder implements the Codec interface.
+func (GobCodec) NewEncoder(w io.Writer) Encoder {
+	return &gobEncoder{enc: gob.NewEncoder(w)}
+}
+
+// NewDecoder implements the Codec interface.
+func (GobCodec) NewDecoder(r io.Reader) Decoder {
+	return &gobDecoder{dec: gob.NewDecoder(r)}
+}
+
+type gobEncoder struct {
+	enc *gob.Encoder
+}
+
+func (e *gobEncoder) Encode(data Data) error {
+	return e.enc.Encode(&data)
+}
+
+type gobDecoder struct {
+	dec *gob.Decoder
+}
+
+func (d *gobDecoder) Decode() (Data, error) {
+	var data Data
+
+	err := d.dec.Decode(&data)
+	return data, err
+}
+
+// SpillBuffer returns an Option that installs a buffer on the link, which never
+// blocks the sender. Link 0 connects the InputSource to the first stage and link
+// n connects the stage at position n to the following stage or the OutputSink.
+// Data beyond the in-memory limit is encoded into segment files and read back in
+// order, and the segment files are removed once the execution ends. Control
+// records are always kept in memory, and Data read back from a segment file
+// replaces the Data that was spilled. The spilled Data is marked as processed
+// once its replacement reaches the OutputSink or is discarded, which requires
+// the replacement to be implemented by a pointer type. Otherwise, the spilled
+// Data is marked as soon as it is read back. Stages are not fused across the link.
+//
+// A buffer on link 0 allows the InputSource to be read while the MemoryBudget is
+// used up, as the budget is then applied as Data leaves the buffer. The bytes
+// of the Data spilled from other links are released until it is read back.
+func SpillBuffer(link int, s Spill) Option {
+	return func(p *Pipeline) {
+		if p.spills == nil {
+			p.spills = make(map[int]Spill)
+		}
+		p.spills[link] = s
+	}
+}
+
+// spillBuffers creates the spill buffers for the execution by link.
+func (e *execution) spillBuffers() (map[int]*spillBuffer, error) {
+	buffers := make(map[int]*spillBuffer, len(e.spills))
+
+	var err error
+	for link, s := range e.spills {
+		if link < 0 || link > len(e.stages) {
+			err = fmt.Errorf("pipeline link %d spill buffer: the link does not exist", link)
+			break
+		}
+
+		var b *spillBuffer
+		if b, err = newSpillBuffer(link, s, e.memory, e.items); err != nil {
+			err = fmt.Errorf("pipeline link %d spill buffer: %v", link, err)
+			break
+		}
+		buffers[link] = b
+	}
+	if err != nil {
+		for _, b := range buffers {
+			b.cleanup()
+		}
+		return nil, err
+	}
+	return buffers, nil
+}
+
+type spillBuffer struct {
+	sync.Mutex
+	Spill
+	link   int
+	dir    string
+	memory *memoryBudget
+	items  *itemTable
+	// admit is set when the Data entering the buffer has not
+	// been admitted to the memory budget yet
+	admit    bool
+	chunks   []*spillChunk
+	inMemory int
+	closed   bool
+	// ready is signalled each time the buffer changes
+	ready chan struct{}
+}
+
+// spillChunk is a run of Data held either in memory or in a segment file.
+type spillChunk struct {
+	data []Data
+	// path is set for the chunks spilled to a segment file
+	path  string
+	count int
+	// items holds the items of the spilled Data, which is decoded into new values
+	// that mark the spilled Data as processed once their items are released
+	items []*item
+	// The writers are set while the segment file is open
+	file *os.File
+	zw   *gzip.Writer
+	bw   *bufio.Writer
+	enc  Encoder
+}
+
+func newSpillBuffer(link int, s Spill, memory *memoryBudget, items *itemTable) (*spillBuffer, error) {
+	if s.SegmentItems <= 0 {
+		s.SegmentItems = DefaultSegmentItems
+	}
+	if s.Codec == nil {
+		s.Codec = GobCodec{}
+	}
+
+	dir, err := ioutil.TempDir(s.Dir, "pipeline-spill")
+	if err != nil {
+		return nil, err
+	}
+
+	return &spillBuffer{
+		Spill:  s,
+		link:   link,
+		dir:    dir,
+		memory: memory,
+		items:  items,
+		admit:  link == 0,
+		ready:  make(chan struct{}, 1),
+	}, nil
+}
+
+// run moves the Data from the input to the output channel until the input
+// is closed or the context expires, and removes the segment files.
+func (b *spillBuffer) run(ctx context.Context, in <-chan Data, out chan<- Data, errQueue *queue.Queue) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := b.fill(ctx, in); err != nil {
+			errQueue.Append(fmt.Errorf("pipeline link %d spill buffer: %v", b.link, err))
+		}
+	}()
+
+	if err := b.drain(ctx, out); err != nil {
+		errQueue.Append(fmt.Errorf("pipeline link %d spill buffer: %v", b.link, err))
+	}
+	<-done
+	b.cleanup()
+}
+
+// fill receives the Data sent on the link until the input is closed or the context expires.
+func (b *spillBuffer) fill(ctx context.Context, in <-chan Data) error {
+	defer func() {
+		b.Lock()
+		b.closed = true
+		b.Unlock()
+		b.signal()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case data, ok := <-in:
+			if !ok {
+				return nil
+			}
+			if err := b.push(ctx, data); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (b *spillBuffer) signal() {
+	select {
+	case b.ready <- struct{}{}:
+	default:
+	}
+}
+
+// push appends the Data to the buffer.
+func (b *spillBuffer) push(ctx context.Context, data Data) error {
+	b.Lock()
+	defer b.Unlock()
+	defer b.signal()
+
+	var tail *spillChunk
+	if len(b.chunks) > 0 {
+		tail = b.chunks[len(b.chunks)-1]
+	}
+
+	_, isControl := data.(control)
+	if isControl || b.inMemory < b.Items {
+		if !isControl {
+			b.inMemory++
+		}
+		if tail == nil || tail.path != "" {
+			tail = new(spillChunk)
+			b.chunks = append(b.chunks, tail)
+		}
+		tail.data = append(tail.data, data)
+		return nil
+	}
+
+	if tail == nil || tail.enc == nil {
+		var err error
+
+		tail, err = b.segment()
+		if err != nil {
+			return err
+		}
+		b.chunks = append(b.chunks, tail)
+	}
+	if err := tail.enc.Encode(data); err != nil {
+		return err
+	}
+
+	tail.count++
+	tail.items = append(tail.items, b.items.spill(ctx, data))
+	if !b.admit {
+		b.memory.release(data)
+	}
+	if tail.count >= b.SegmentItems {
+		return tail.seal()
+	}
+	return nil
+}
+
+// segment creates a new segment file.
+func (b *spillBuffer) segment() (*spillChunk, error) {
+	f, err := ioutil.TempFile(b.dir, "segment")
+	if err != nil {
+		return nil, err
+	}
+
+	c := &spillChunk{
+		path: f.Name(),
+		file: f,
+	}
+
+	var w io.Writer = f
+	if b.Compress {
+		c.zw = gzip.NewWriter(f)
+		w = c.zw
+	}
+	c.bw = bufio.NewWriter(w)
+	c.enc = b.Codec.NewEncoder(c.bw)
+	return c, nil
+}
+
+// seal flushes and closes the segment file if it is still open.
+func (c *spillChunk) seal() error {
+	if c.enc == nil {
+		return nil
+	}
+	c.enc = nil
+
+	err := c.bw.Flush()
+	if c.zw != nil {
+		if zerr := c.zw.Close(); err == nil {
+			err = zerr
+		}
+	}
+	if ferr := c.file.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// drain sends the buffered Data to the output channel until the input
+// has been closed and the buffer is empty, or the context expires.
+func (b *spillBuffer) drain(ctx context.Context, out chan<- Data) error {
+	for {
+		data, spilled, closed, err := b.pop()
+		if err != nil {
+			return err
+		}
+
+		if len(data) == 0 {
+			if closed {
+				return nil
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-b.ready:
+			}
+			continue
+		}
+
+		for _, d := range data {
+			if (spilled || b.admit) && !b.memory.admit(ctx, d) {
+				return nil
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case out <- d:
+			}
+		}
+	}
+}
+
+// pop removes the next Data held in memory or all the Data of the next segment
+// file from the buffer. It also reports whether the Data was spilled and
+// whether the buffer has been closed.
+func (b *spillBuffer) pop() ([]Data, bool, bool, error) {
+	b.Lock()
+	if len(b.chunks) == 0 {
+		closed := b.closed
+		b.Unlock()
+		return nil, false, closed, nil
+	}
+
+	c := b.chunks[0]
+	if c.path == "" {
+		data := c.data[0]
+		c.data = c.data[1:]
+		if len(c.data) == 0 {
+			b.chunks = b.chunks[1:]
+		}
+		if _, ok := data.(control); !ok {
+			b.inMemory--
+		}
+		b.Unlock()
+		return []Data{data}, false, false, nil
+	}
+
+	b.chunks = b.chunks[1:]
+	err := c.seal()
+	b.Unlock()
+	if err != nil {
+		return nil, true, false, err
+	}
+
+	data, err := b.read(c)
+	return data, true, false, err
+}
+
+// read decodes the Data of the segment file and removes the file.
+func (b *spillBuffer) read(c *spillChunk) ([]Data, error) {
+	defer os.Remove(c.path)
+
+	f, err := os.Open(c.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = bufio.NewReader(f)
+	if b.Compress {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	dec := b.Codec.NewDecoder(r)
+	data := make([]Data, 0, c.count)
+	for i := 0; i < c.count; i++ {
+		d, err := dec.Decode()
+		if err != nil {
+			return nil, err
+		}
+		if trackable(d) {
+			b.items.attach(d, c.items[i])
+		} else {
+			b.items.release(c.items[i])
+		}
+		data = append(data, d)
+	}
+	return data, nil
+}
+
+// cleanup closes the segment files still in the buffer and removes them.
+func (b *spillBuffer) cleanup() {
+	b.Lock()
+	defer b.Unlock()
+
+	for _, c := range b.chunks {
+		if c.path != "" {
+			_ = c.seal()
+		}
+	}
+	b.chunks = nil
+	os.RemoveAll(b.dir)
+}
@@ -0,0 +1,158 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/gob"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func init() {
+	gob.Register(&spillData{})
+}
+
+func TestSpillBuffer(t *testing.T) {
+	dir := t.TempDir()
+	codec := new(countingCodec)
+	task := TaskFunc(func(_ context.Context, d Data) (Data, error) {
+		time.Sleep(time.Millisecond)
+		return d, nil
+	})
+
+	src := &sourceStub{data: spillDataValues(50, 0)}
+	sink := new(sinkStub)
+
+	p := NewPipeline(FIFO(task)).With(SpillBuffer(0, Spill{
+		Dir:          dir,
+		Items:        5,
+		SegmentItems: 4,
+		Codec:        codec,
+		Compress:     true,
+	}))
+	if err := p.Execute(context.TODO(), src, sink); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+	if got := atomic.LoadInt32(&codec.encoded); got == 0 {
+		t.Errorf("Expected data to be spilled to the segment files")
+	}
+
+	var got []int
+	for _, d := range sink.data {
+		got = append(got, d.(*spillData).Val)
+	}
+	var want []int
+	for _, d := range src.data {
+		want = append(want, d.(*spillData).Val)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Data does not match.\nWanted:%v\nGot:%v\n", want, got)
+	}
+	// The spilled Data is marked once its decoded copy has been consumed
+	for i, d := range src.data {
+		if atomic.LoadInt32(&d.(*spillData).processed) == 0 {
+			t.Errorf("Data %d not processed", i)
+		}
+	}
+
+	if files, err := ioutil.ReadDir(dir); err != nil || len(files) != 0 {
+		t.Errorf("Expected the segment files to be removed, got %d files", len(files))
+	}
+}
+
+func TestSpillBufferMemoryBudget(t *testing.T) {
+	// The task only returns once the source has been exhausted
+	exhausted := make(chan struct{})
+	task := TaskFunc(func(ctx context.Context, d Data) (Data, error) {
+		select {
+		case <-exhausted:
+		case <-ctx.Done():
+		}
+		return d, nil
+	})
+
+	src := &exhaustingSource{
+		sourceStub: sourceStub{data: spillDataValues(20, 10)},
+		exhausted:  exhausted,
+	}
+	sink := new(sinkStub)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	p := NewPipeline(FIFO(task)).With(MemoryBudget(30), SpillBuffer(0, Spill{Dir: t.TempDir(), Items: 2}))
+	if err := p.Execute(ctx, src, sink); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+	if len(sink.data) != 20 {
+		t.Errorf("Expected 20 data to reach the sink, got %d", len(sink.data))
+	}
+	if used := p.Metrics()["memory_in_use_bytes"]; used != 0 {
+		t.Errorf("Expected all bytes to be released, got %v", used)
+	}
+}
+
+func TestSpillBufferUnknownLink(t *testing.T) {
+	p := NewPipeline(FIFO(makePassthroughTask())).With(SpillBuffer(2, Spill{}))
+
+	err := p.Execute(context.TODO(), &sourceStub{data: spillDataValues(1, 0)}, new(sinkStub))
+	if OutcomeOf(err) != Failed {
+		t.Errorf("Expected the execution to fail for a link that does not exist, got %v", err)
+	}
+}
+
+type spillData struct {
+	Val   int
+	Bytes int64
+	// processed is not encoded into the segment files
+	processed int32
+}
+
+func (s *spillData) Clone() Data      { return &spillData{Val: s.Val, Bytes: s.Bytes} }
+func (s *spillData) MarkAsProcessed() { atomic.StoreInt32(&s.processed, 1) }
+func (s *spillData) Size() int64      { return s.Bytes }
+
+func spillDataValues(num int, size int64) []Data {
+	out := make([]Data, num)
+
+	for i := 0; i < len(out); i++ {
+		out[i] = &spillData{Val: i, Bytes: size}
+	}
+	return out
+}
+
+type exhaustingSource struct {
+	sourceStub
+	exhausted chan struct{}
+}
+
+func (s *exhaustingSource) Next(ctx context.Context) bool {
+	if s.sourceStub.Next(ctx) {
+		return true
+	}
+
+	close(s.exhausted)
+	return false
+}
+
+type countingCodec struct {
+	GobCodec
+	encoded int32
+}
+
+func (c *countingCodec) NewEncoder(w io.Writer) Encoder {
+	return &countingEncoder{Encoder: c.GobCodec.NewEncoder(w), codec: c}
+}
+
+type countingEncoder struct {
+	Encoder
+	codec *countingCodec
+}
+
+func (e *countingEncoder) Encode(data Data) error {
+	atomic.AddInt32(&e.codec.encoded, 1)
+	return e.Encoder.Encode(data)
+}
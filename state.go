@@ -0,0 +1,406 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+func init() {
+	gob.Register([]interface{}{})
+	gob.Register(map[string]interface{}{})
+}
+
+// StateEntry is a value held by a StateBackend.
+type StateEntry struct {
+	Value interface{}
+	// Expires is the time the entry expires, or the zero time if it never does.
+	Expires time.Time
+}
+
+func (e StateEntry) expired(now time.Time) bool {
+	return !e.Expires.IsZero() && now.After(e.Expires)
+}
+
+// StateBackend stores the keyed state of the Tasks in a pipeline.
+type StateBackend interface {
+	// Get returns the entry stored for the key.
+	Get(key string) (StateEntry, bool)
+
+	// Set stores the entry for the key.
+	Set(key string, entry StateEntry)
+
+	// Delete removes the entry stored for the key.
+	Delete(key string)
+
+	// Range calls f for each stored entry until f returns false.
+	Range(f func(key string, entry StateEntry) bool)
+}
+
+// Snapshotter is implemented by a StateBackend that can persist its entries.
+// The pipeline restores the entries before the execution and snapshots them
+// once the execution ends.
+type Snapshotter interface {
+	Snapshot() error
+	Restore() error
+}
+
+// MemoryBackend is a StateBackend that holds the entries in memory.
+type MemoryBackend struct {
+	sync.RWMutex
+	entries map[string]StateEntry
+}
+
+// NewMemoryBackend returns an empty MemoryBackend.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{entries: make(map[string]StateEntry)}
+}
+
+// Get implements the StateBackend interface.
+func (m *MemoryBackend) Get(key string) (StateEntry, bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	entry, ok := m.entries[key]
+	return entry, ok
+}
+
+// Set implements the StateBackend interface.
+func (m *MemoryBackend) Set(key string, entry StateEntry) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.entries[key] = entry
+}
+
+// Delete implements the StateBackend interface.
+func (m *MemoryBackend) Delete(key string) {
+	m.Lock()
+	defer m.Unlock()
+
+	delete(m.entries, key)
+}
+
+// Range implements the StateBackend interface.
+func (m *MemoryBackend) Range(f func(key string, entry StateEntry) bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	for key, entry := range m.entries {
+		if !f(key, entry) {
+			return
+		}
+	}
+}
+
+// FileBackend is a StateBackend that holds the entries in memory and
+// snapshots them to a file. Values are encoded with encoding/gob, so
+// the concrete types stored in the state must be registered with gob.
+type FileBackend struct {
+	*MemoryBackend
+	path string
+}
+
+// NewFileBackend returns a FileBackend that snapshots the entries to path.
+func NewFileBackend(path string) *FileBackend {
+	return &FileBackend{
+		MemoryBackend: NewMemoryBackend(),
+		path:          path,
+	}
+}
+
+// Snapshot writes the unexpired entries to the file.
+func (f *FileBackend) Snapshot() error {
+	now := time.Now()
+	entries := make(map[string]StateEntry)
+	f.Range(func(key string, entry StateEntry) bool {
+		if !entry.expired(now) {
+			entries[key] = entry
+		}
+		return true
+	})
+
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := gob.NewEncoder(tmp).Encode(entries); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// Replace the previous snapshot atomically
+	return os.Rename(tmp.Name(), f.path)
+}
+
+// Restore replaces the entries with those from the file, if it exists.
+func (f *FileBackend) Restore() error {
+	file, err := os.Open(f.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	entries := make(map[string]StateEntry)
+	if err := gob.NewDecoder(file).Decode(&entries); err != nil {
+		return err
+	}
+
+	f.Lock()
+	f.entries = entries
+	f.Unlock()
+	return nil
+}
+
+// stateStore adds expiry and atomic updates to a StateBackend.
+type stateStore struct {
+	sync.Mutex
+	backend StateBackend
+	ttl     time.Duration
+}
+
+// KeyedState returns an Option that makes keyed state available to the Tasks
+// through StateFromContext. Entries are held by the provided backend and expire
+// once they have not been updated for the ttl. A zero ttl disables expiry.
+func KeyedState(backend StateBackend, ttl time.Duration) Option {
+	return func(p *Pipeline) {
+		p.state = &stateStore{backend: backend, ttl: ttl}
+	}
+}
+
+func (s *stateStore) get(key string) (interface{}, bool) {
+	entry, ok := s.backend.Get(key)
+	if !ok {
+		return nil, false
+	}
+	if !entry.expired(time.Now()) {
+		return entry.Value, true
+	}
+
+	// Expired entries are only deleted under the lock, so that
+	// a value set by a concurrent update is not lost
+	s.Lock()
+	defer s.Unlock()
+
+	return s.lookup(key)
+}
+
+// lookup returns the value for the key and deletes the entry if it
+// has expired. The caller must hold the lock.
+func (s *stateStore) lookup(key string) (interface{}, bool) {
+	entry, ok := s.backend.Get(key)
+	if !ok {
+		return nil, false
+	}
+	if entry.expired(time.Now()) {
+		s.backend.Delete(key)
+		return nil, false
+	}
+	return entry.Value, true
+}
+
+// update atomically replaces the value stored for the key with the one returned by f.
+func (s *stateStore) update(key string, f func(interface{}, bool) interface{}) {
+	s.Lock()
+	defer s.Unlock()
+
+	var expires time.Time
+	if s.ttl > 0 {
+		expires = time.Now().Add(s.ttl)
+	}
+
+	value, ok := s.lookup(key)
+	s.backend.Set(key, StateEntry{Value: f(value, ok), Expires: expires})
+}
+
+func (s *stateStore) delete(key string) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.backend.Delete(key)
+}
+
+func (s *stateStore) restore() error {
+	if sn, ok := s.backend.(Snapshotter); ok {
+		return sn.Restore()
+	}
+	return nil
+}
+
+func (s *stateStore) snapshot() error {
+	if sn, ok := s.backend.(Snapshotter); ok {
+		return sn.Snapshot()
+	}
+	return nil
+}
+
+type stateContextKey struct{}
+
+// State provides the keyed state of a single pipeline stage.
+type State struct {
+	store *stateStore
+	stage int
+}
+
+// StateFromContext returns the keyed state for the stage executing the Task,
+// or nil when the KeyedState option has not been provided to the pipeline.
+func StateFromContext(ctx context.Context) *State {
+	s, _ := ctx.Value(stateContextKey{}).(*State)
+	return s
+}
+
+// stageContext returns a context that provides the Tasks at the
+// specified pipeline position with their stage-scoped values.
+func stageContext(ctx context.Context, store *stateStore, position int) context.Context {
+	if store == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, stateContextKey{}, &State{store: store, stage: position})
+}
+
+// withPosition returns a context that scopes the state to the stage at position.
+func withPosition(ctx context.Context, position int) context.Context {
+	if s := StateFromContext(ctx); s != nil && s.stage != position {
+		return stageContext(ctx, s.store, position)
+	}
+	return ctx
+}
+
+func (s *State) key(name, key string) string {
+	return fmt.Sprintf("%d/%s/%s", s.stage, name, key)
+}
+
+// Value returns the named ValueState scoped to the key.
+func (s *State) Value(name, key string) *ValueState {
+	return &ValueState{store: s.store, key: s.key(name, key)}
+}
+
+// List returns the named ListState scoped to the key.
+func (s *State) List(name, key string) *ListState {
+	return &ListState{store: s.store, key: s.key(name, key)}
+}
+
+// Map returns the named MapState scoped to the key.
+func (s *State) Map(name, key string) *MapState {
+	return &MapState{store: s.store, key: s.key(name, key)}
+}
+
+// ValueState holds a single value.
+type ValueState struct {
+	store *stateStore
+	key   string
+}
+
+// Get returns the value and whether it has been set.
+func (v *ValueState) Get() (interface{}, bool) {
+	return v.store.get(v.key)
+}
+
+// Set replaces the value.
+func (v *ValueState) Set(value interface{}) {
+	v.store.update(v.key, func(interface{}, bool) interface{} { return value })
+}
+
+// Update atomically replaces the value with the one returned by f.
+func (v *ValueState) Update(f func(value interface{}, ok bool) interface{}) {
+	v.store.update(v.key, f)
+}
+
+// Clear removes the value.
+func (v *ValueState) Clear() {
+	v.store.delete(v.key)
+}
+
+// ListState holds a list of values.
+type ListState struct {
+	store *stateStore
+	key   string
+}
+
+// Get returns a copy of the values in the list.
+func (l *ListState) Get() []interface{} {
+	value, _ := l.store.get(l.key)
+	list, _ := value.([]interface{})
+	return append([]interface{}(nil), list...)
+}
+
+// Add appends the values to the list.
+func (l *ListState) Add(values ...interface{}) {
+	l.store.update(l.key, func(value interface{}, _ bool) interface{} {
+		list, _ := value.([]interface{})
+		return append(append([]interface{}(nil), list...), values...)
+	})
+}
+
+// Clear removes all values from the list.
+func (l *ListState) Clear() {
+	l.store.delete(l.key)
+}
+
+// MapState holds values by their map key.
+type MapState struct {
+	store *stateStore
+	key   string
+}
+
+// Get returns the value stored for the map key.
+func (m *MapState) Get(mapKey string) (interface{}, bool) {
+	value, _ := m.store.get(m.key)
+	entries, _ := value.(map[string]interface{})
+	v, ok := entries[mapKey]
+	return v, ok
+}
+
+// Put stores the value for the map key.
+func (m *MapState) Put(mapKey string, v interface{}) {
+	m.update(func(entries map[string]interface{}) { entries[mapKey] = v })
+}
+
+// Delete removes the value stored for the map key.
+func (m *MapState) Delete(mapKey string) {
+	m.update(func(entries map[string]interface{}) { delete(entries, mapKey) })
+}
+
+// Entries returns a copy of all the values in the map.
+func (m *MapState) Entries() map[string]interface{} {
+	value, _ := m.store.get(m.key)
+	entries, _ := value.(map[string]interface{})
+	return copyEntries(entries)
+}
+
+// Clear removes all values from the map.
+func (m *MapState) Clear() {
+	m.store.delete(m.key)
+}
+
+func (m *MapState) update(f func(map[string]interface{})) {
+	m.store.update(m.key, func(value interface{}, _ bool) interface{} {
+		entries, _ := value.(map[string]interface{})
+		entries = copyEntries(entries)
+		f(entries)
+		return entries
+	})
+}
+
+func copyEntries(entries map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(entries))
+	for k, v := range entries {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,144 @@
+package pipeline
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeyedState(t *testing.T) {
+	backend := NewMemoryBackend()
+	src := &sourceStub{data: stringDataValues(6)}
+	sink := new(sinkStub)
+
+	p := NewPipeline(FIFO(makeCountingTask()), FIFO(makeCountingTask())).With(KeyedState(backend, 0))
+	if err := p.Execute(context.TODO(), src, sink); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+
+	// Each stage holds its own state for the keys
+	for _, key := range []string{"1/count/even", "2/count/even", "1/count/odd", "2/count/odd"} {
+		if entry, ok := backend.Get(key); !ok || entry.Value != 3 {
+			t.Errorf("Expected a count of 3 for %s, got %v", key, entry.Value)
+		}
+	}
+}
+
+func TestKeyedStateFused(t *testing.T) {
+	backend := NewMemoryBackend()
+	src := &sourceStub{data: stringDataValues(2)}
+	sink := new(sinkStub)
+
+	p := NewPipeline(FIFO(makeCountingTask()), FIFO(makeCountingTask())).With(KeyedState(backend, 0), FuseStages())
+	if err := p.Execute(context.TODO(), src, sink); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+	if entry, ok := backend.Get("2/count/odd"); !ok || entry.Value != 1 {
+		t.Errorf("Expected a count of 1 for the second stage, got %v", entry.Value)
+	}
+}
+
+func TestStateExpiry(t *testing.T) {
+	store := &stateStore{backend: NewMemoryBackend(), ttl: 10 * time.Millisecond}
+	s := &State{store: store, stage: 1}
+
+	s.Value("last", "key").Set("value")
+	if v, ok := s.Value("last", "key").Get(); !ok || v != "value" {
+		t.Errorf("Expected the value to be set, got %v", v)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := s.Value("last", "key").Get(); ok {
+		t.Errorf("Expected the value to expire")
+	}
+}
+
+func TestStateExpiryConcurrentUpdate(t *testing.T) {
+	backend := &updatingBackend{MemoryBackend: NewMemoryBackend()}
+	store := &stateStore{backend: backend, ttl: time.Hour}
+	backend.Set("key", StateEntry{Value: "old", Expires: time.Now().Add(-time.Second)})
+
+	// The entry is updated after being found expired and before being deleted
+	backend.hook = func() {
+		store.update("key", func(interface{}, bool) interface{} { return "new" })
+	}
+	if v, ok := store.get("key"); !ok || v != "new" {
+		t.Errorf("Expected the updated value, got %v", v)
+	}
+	if entry, ok := backend.Get("key"); !ok || entry.Value != "new" {
+		t.Errorf("Expected the updated value to be kept, got %v", entry.Value)
+	}
+}
+
+// updatingBackend calls the hook once, after the first Get.
+type updatingBackend struct {
+	*MemoryBackend
+	hook func()
+}
+
+func (b *updatingBackend) Get(key string) (StateEntry, bool) {
+	entry, ok := b.MemoryBackend.Get(key)
+	if hook := b.hook; hook != nil {
+		b.hook = nil
+		hook()
+	}
+	return entry, ok
+}
+
+func TestListAndMapState(t *testing.T) {
+	s := &State{store: &stateStore{backend: NewMemoryBackend()}, stage: 1}
+
+	l := s.List("seen", "key")
+	l.Add("a", "b")
+	l.Add("c")
+	if got := l.Get(); len(got) != 3 || got[2] != "c" {
+		t.Errorf("Unexpected list state: %v", got)
+	}
+
+	m := s.Map("attrs", "key")
+	m.Put("a", 1)
+	m.Put("b", 2)
+	m.Delete("a")
+	if _, ok := m.Get("a"); ok {
+		t.Errorf("Expected the map entry to be deleted")
+	}
+	if v, ok := m.Get("b"); !ok || v != 2 || len(m.Entries()) != 1 {
+		t.Errorf("Unexpected map state: %v", m.Entries())
+	}
+}
+
+func TestFileBackendRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.snapshot")
+
+	for i := 1; i <= 2; i++ {
+		backend := NewFileBackend(path)
+		src := &sourceStub{data: stringDataValues(2)}
+		sink := new(sinkStub)
+
+		p := NewPipeline(FIFO(makeCountingTask())).With(KeyedState(backend, time.Hour))
+		if err := p.Execute(context.TODO(), src, sink); err != nil {
+			t.Errorf("Error executing the Pipeline: %v", err)
+		}
+		// The counts continue from the restored snapshot
+		if entry, ok := backend.Get("1/count/even"); !ok || entry.Value != i {
+			t.Errorf("Expected a count of %d after execution %d, got %v", i, i, entry.Value)
+		}
+	}
+}
+
+// makeCountingTask returns a Task that keeps a running count for odd and even values.
+func makeCountingTask() Task {
+	return TaskFunc(func(ctx context.Context, d Data) (Data, error) {
+		key := "even"
+		if v := d.(*stringData).val; (v[len(v)-1]-'0')%2 == 1 {
+			key = "odd"
+		}
+
+		StateFromContext(ctx).Value("count", key).Update(func(value interface{}, _ bool) interface{} {
+			count, _ := value.(int)
+			return count + 1
+		})
+		return d, nil
+	})
+}
@@ -0,0 +1,103 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// StageTrace records the Task calls made by a stage for the Data passed to Trace.
+type StageTrace struct {
+	// Position is the position of the stage in the pipeline.
+	Position int
+
+	// Name is the name of the stage within its StageGroup, if any.
+	Name string
+
+	// Calls holds each Task call made by the stage, including the calls
+	// for each copy of the Data made by Broadcast and Parallel.
+	Calls []TaskCall
+}
+
+// TaskCall records a single Task call.
+type TaskCall struct {
+	// Input is a copy of the Data passed to the Task.
+	Input Data
+
+	// Output is a copy of the Data returned by the Task.
+	Output Data
+
+	// Err is the error returned by the Task.
+	Err error
+
+	// Duration is the time taken by the Task.
+	Duration time.Duration
+}
+
+// Trace sends the Data alone through copies of the pipeline stages and returns
+// the record of the Task calls made by each stage. The middleware used by the
+// pipeline is applied, while the options configuring the executions are not.
+// Stage implementations outside of this package are executed without being
+// traced. The error of the execution is returned along with the records.
+func (p *Pipeline) Trace(ctx context.Context, data Data) ([]StageTrace, error) {
+	t := &tracer{stages: make([]StageTrace, len(p.stages))}
+
+	stages := cloneStages(p.stages)
+	for i := range stages {
+		t.stages[i].Position = i + 1
+		if i < len(p.names) {
+			t.stages[i].Name = p.names[i]
+		}
+		stages[i] = Use(stages[i], t.middleware)
+	}
+
+	tp := NewPipeline(stages...).Use(p.middleware...)
+	tp.names = p.names
+	_, err := tp.Collect(ctx, &traceSource{data: data})
+	return t.stages, err
+}
+
+type tracer struct {
+	sync.Mutex
+	stages []StageTrace
+}
+
+func (t *tracer) middleware(next Task) Task {
+	return TaskFunc(func(ctx context.Context, data Data) (Data, error) {
+		call := TaskCall{Input: data.Clone()}
+
+		start := time.Now()
+		out, err := next.Process(ctx, data)
+		call.Duration = time.Since(start)
+		call.Err = err
+		if out != nil {
+			call.Output = out.Clone()
+		}
+
+		if info, ok := StageInfoFromContext(ctx); ok && info.Position > 0 && info.Position <= len(t.stages) {
+			t.Lock()
+			s := &t.stages[info.Position-1]
+			s.Calls = append(s.Calls, call)
+			t.Unlock()
+		}
+		return out, err
+	})
+}
+
+// traceSource is the InputSource providing the traced Data.
+type traceSource struct {
+	data Data
+	read bool
+}
+
+func (s *traceSource) Next(context.Context) bool {
+	if s.read {
+		return false
+	}
+
+	s.read = true
+	return true
+}
+
+func (s *traceSource) Data() Data   { return s.data }
+func (s *traceSource) Error() error { return nil }
@@ -0,0 +1,58 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	suffix := func(s string) Task {
+		return TaskFunc(func(_ context.Context, d Data) (Data, error) {
+			return &stringData{val: d.(*stringData).val + s}, nil
+		})
+	}
+	task := makePassthroughTask()
+
+	p := NewPipeline(FIFO(suffix("a")), Broadcast(suffix("b"), suffix("c")), Parallel(task, task), Group("g", FIFO(suffix("d"))))
+	traces, err := p.Trace(context.TODO(), &stringData{val: "x"})
+	if err != nil {
+		t.Errorf("Error tracing the Pipeline: %v", err)
+	}
+	if len(traces) != 4 {
+		t.Fatalf("Expected a record for each of the 4 stages, got %d", len(traces))
+	}
+
+	first := traces[0]
+	if len(first.Calls) != 1 || first.Calls[0].Input.(*stringData).val != "x" || first.Calls[0].Output.(*stringData).val != "xa" {
+		t.Errorf("Unexpected record for the first stage: %+v", first)
+	}
+	outputs := make(map[string]bool)
+	for _, call := range traces[1].Calls {
+		outputs[call.Output.(*stringData).val] = true
+	}
+	if !outputs["xab"] || !outputs["xac"] {
+		t.Errorf("Expected the outputs of both Broadcast tasks, got %v", outputs)
+	}
+	// Parallel passes a copy of each Broadcast output to both tasks
+	if n := len(traces[2].Calls); n != 4 {
+		t.Errorf("Expected 4 calls for the Parallel stage, got %d", n)
+	}
+	if last := traces[3]; last.Name != "g/1" || last.Position != 4 || len(last.Calls) != 2 {
+		t.Errorf("Unexpected record for the last stage: %+v", last)
+	}
+}
+
+func TestTraceError(t *testing.T) {
+	failing := TaskFunc(func(_ context.Context, d Data) (Data, error) {
+		return nil, errors.New("task error")
+	})
+
+	traces, err := NewPipeline(FIFO(makePassthroughTask()), FIFO(failing)).Trace(context.TODO(), &stringData{val: "x"})
+	if OutcomeOf(err) != Failed {
+		t.Errorf("Expected the trace to fail, got %v", err)
+	}
+	if calls := traces[1].Calls; len(calls) != 1 || calls[0].Err == nil || calls[0].Output != nil {
+		t.Errorf("Expected the error in the record of the failing stage, got %+v", traces[1])
+	}
+}
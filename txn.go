@@ -0,0 +1,247 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrNoCheckpointing is returned when a TransactionalSink is used
+// without providing the Checkpointing option to the pipeline.
+var ErrNoCheckpointing = errors.New("transactional sink requires checkpointing")
+
+// TransactionalSink is implemented by OutputSinks that take part in a two-phase
+// commit with the pipeline checkpoints. The Data consumed between two checkpoints
+// belongs to a single transaction, which has the ID of the checkpoint that ends
+// it. The transaction is prepared when the checkpoint barrier reaches the sink
+// and is only committed once the checkpoint has been saved, so the output is
+// committed exactly once for the source offsets stored by the checkpoint.
+type TransactionalSink interface {
+	OutputSink
+
+	// BeginTxn starts the transaction for the Data consumed next.
+	BeginTxn(ctx context.Context, id uint64) error
+
+	// Prepare makes the transaction durable without making it visible.
+	Prepare(ctx context.Context, id uint64) error
+
+	// Commit makes the prepared transaction visible. Commit is also called
+	// for the latest checkpoint when an execution resumes, so it must succeed
+	// for transactions that have already been committed.
+	Commit(ctx context.Context, id uint64) error
+
+	// Abort discards the transaction.
+	Abort(ctx context.Context, id uint64) error
+}
+
+// beginTxn resumes the TransactionalSink from the latest checkpoint and starts
+// the first transaction of the execution.
+func (e *execution) beginTxn(ctx context.Context, sink OutputSink, restored *Checkpoint) error {
+	ts, ok := sink.(TransactionalSink)
+	if !ok {
+		return nil
+	}
+	if e.checkpointer == nil {
+		return ErrNoCheckpointing
+	}
+
+	// Complete the commit that could have been interrupted
+	if restored != nil {
+		if err := ts.Commit(ctx, restored.ID); err != nil {
+			return err
+		}
+	}
+	if err := ts.BeginTxn(ctx, e.checkpointer.nextID); err != nil {
+		return err
+	}
+
+	e.txnSink = ts
+	e.txnID = e.checkpointer.nextID
+	e.txnOpen = true
+	return nil
+}
+
+// commitTxn saves the checkpoint as part of the two-phase commit with the TransactionalSink.
+func (e *execution) commitTxn(ctx context.Context, cp *Checkpoint, final bool) error {
+	ts := e.txnSink
+
+	if err := ts.Prepare(ctx, cp.ID); err != nil {
+		return fmt.Errorf("pipeline output sink: prepare %d: %v", cp.ID, err)
+	}
+	if err := e.checkpoints.Save(cp); err != nil {
+		return fmt.Errorf("pipeline checkpoint %d: %v", cp.ID, err)
+	}
+	// The checkpoint is durable, so the transaction is committed
+	// again when the next execution resumes if this call fails
+	e.txnOpen = false
+	if err := ts.Commit(ctx, cp.ID); err != nil {
+		return fmt.Errorf("pipeline output sink: commit %d: %v", cp.ID, err)
+	}
+	if final {
+		return nil
+	}
+
+	if err := ts.BeginTxn(ctx, cp.ID+1); err != nil {
+		return fmt.Errorf("pipeline output sink: begin %d: %v", cp.ID+1, err)
+	}
+	e.txnID = cp.ID + 1
+	e.txnOpen = true
+	return nil
+}
+
+// abortTxn discards the open transaction once the OutputSink stops consuming
+// data. The execution context has expired by then, so it is not used.
+func (e *execution) abortTxn() {
+	if e.txnSink == nil || !e.txnOpen {
+		return
+	}
+
+	e.txnOpen = false
+	if err := e.txnSink.Abort(context.Background(), e.txnID); err != nil {
+		e.errQueue.Append(fmt.Errorf("pipeline output sink: abort %d: %v", e.txnID, err))
+	}
+}
+
+const (
+	pendingExt   = ".pending"
+	preparedExt  = ".prepared"
+	committedExt = ".out"
+)
+
+// FileTxnSink is a TransactionalSink that writes one line per Data to a file per
+// transaction. Transactions are written to a pending file, renamed once prepared,
+// and renamed again to the committed file with the .out extension. The committed
+// files sort in the order of the transactions.
+type FileTxnSink struct {
+	sync.Mutex
+	dir     string
+	marshal func(Data) ([]byte, error)
+	file    *os.File
+	buf     *bufio.Writer
+}
+
+// NewFileTxnSink returns a FileTxnSink that writes the files to dir and
+// uses the marshal function to encode each Data as a line.
+func NewFileTxnSink(dir string, marshal func(Data) ([]byte, error)) *FileTxnSink {
+	return &FileTxnSink{dir: dir, marshal: marshal}
+}
+
+func (f *FileTxnSink) path(id uint64, ext string) string {
+	return filepath.Join(f.dir, fmt.Sprintf("%020d%s", id, ext))
+}
+
+// BeginTxn implements the TransactionalSink interface.
+func (f *FileTxnSink) BeginTxn(_ context.Context, id uint64) error {
+	f.Lock()
+	defer f.Unlock()
+
+	// Remove the transactions left behind by a failed execution
+	files, err := ioutil.ReadDir(f.dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		name := fi.Name()
+		ext := filepath.Ext(name)
+		if ext != pendingExt && ext != preparedExt {
+			continue
+		}
+		if n, err := strconv.ParseUint(strings.TrimSuffix(name, ext), 10, 64); err == nil && n >= id {
+			if err := os.Remove(filepath.Join(f.dir, name)); err != nil {
+				return err
+			}
+		}
+	}
+
+	file, err := os.Create(f.path(id, pendingExt))
+	if err != nil {
+		return err
+	}
+	f.file = file
+	f.buf = bufio.NewWriter(file)
+	return nil
+}
+
+// Consume implements the OutputSink interface.
+func (f *FileTxnSink) Consume(_ context.Context, data Data) error {
+	line, err := f.marshal(data)
+	if err != nil {
+		return err
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	if f.buf == nil {
+		return errors.New("no transaction has been started")
+	}
+	if _, err := f.buf.Write(line); err != nil {
+		return err
+	}
+	return f.buf.WriteByte('\n')
+}
+
+// Prepare implements the TransactionalSink interface.
+func (f *FileTxnSink) Prepare(_ context.Context, id uint64) error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.file == nil {
+		return errors.New("no transaction has been started")
+	}
+	if err := f.closeFile(); err != nil {
+		return err
+	}
+	return os.Rename(f.path(id, pendingExt), f.path(id, preparedExt))
+}
+
+// Commit implements the TransactionalSink interface.
+func (f *FileTxnSink) Commit(_ context.Context, id uint64) error {
+	f.Lock()
+	defer f.Unlock()
+
+	err := os.Rename(f.path(id, preparedExt), f.path(id, committedExt))
+	if os.IsNotExist(err) {
+		// The transaction has already been committed
+		return nil
+	}
+	return err
+}
+
+// Abort implements the TransactionalSink interface.
+func (f *FileTxnSink) Abort(_ context.Context, id uint64) error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.file != nil {
+		f.file.Close()
+		f.file, f.buf = nil, nil
+	}
+	for _, ext := range []string{pendingExt, preparedExt} {
+		if err := os.Remove(f.path(id, ext)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FileTxnSink) closeFile() error {
+	defer func() { f.file, f.buf = nil, nil }()
+
+	if err := f.buf.Flush(); err != nil {
+		f.file.Close()
+		return err
+	}
+	if err := f.file.Sync(); err != nil {
+		f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
@@ -0,0 +1,108 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileTxnSink(t *testing.T) {
+	dir := t.TempDir()
+	store := NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoint"))
+	src := &checkpointSourceStub{sourceStub: sourceStub{data: stringDataValues(20)}}
+
+	p := NewPipeline(FIFO(makePassthroughTask())).With(Checkpointing(store, time.Nanosecond))
+	if err := p.Execute(context.TODO(), src, NewFileTxnSink(dir, marshalStringData)); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+
+	assertCommittedLines(t, dir, 20)
+}
+
+func TestFileTxnSinkResume(t *testing.T) {
+	dir := t.TempDir()
+	store := NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoint"))
+	data := stringDataValues(20)
+
+	failing := TaskFunc(func(_ context.Context, d Data) (Data, error) {
+		if d.(*stringData).val == "10" {
+			return nil, errors.New("task error")
+		}
+		return d, nil
+	})
+
+	src := &checkpointSourceStub{sourceStub: sourceStub{data: data}}
+	p := NewPipeline(FIFO(failing)).With(Checkpointing(store, time.Nanosecond))
+	if err := p.Execute(context.TODO(), src, NewFileTxnSink(dir, marshalStringData)); OutcomeOf(err) != Failed {
+		t.Fatalf("Expected the execution to fail, got %v", err)
+	}
+	// The execution can be cancelled before the last barrier reaches the sink
+	if n := len(committedLines(t, dir)); n > 10 {
+		t.Fatalf("Expected at most 10 committed lines, got %d", n)
+	}
+
+	// Resume the execution from the latest checkpoint
+	src = &checkpointSourceStub{sourceStub: sourceStub{data: data}}
+	p = NewPipeline(FIFO(makePassthroughTask())).With(Checkpointing(store, time.Nanosecond))
+	if err := p.Execute(context.TODO(), src, NewFileTxnSink(dir, marshalStringData)); err != nil {
+		t.Errorf("Error executing the Pipeline: %v", err)
+	}
+	assertCommittedLines(t, dir, 20)
+}
+
+func TestTxnSinkRequiresCheckpointing(t *testing.T) {
+	src := &sourceStub{data: stringDataValues(1)}
+
+	p := NewPipeline(FIFO(makePassthroughTask()))
+	if err := p.Execute(context.TODO(), src, NewFileTxnSink(t.TempDir(), marshalStringData)); !errors.Is(err, ErrNoCheckpointing) {
+		t.Errorf("Error did not match the expectation: %v", err)
+	}
+}
+
+// assertCommittedLines checks that the committed files hold the first num values exactly once.
+func assertCommittedLines(t *testing.T, dir string, num int) {
+	if lines := committedLines(t, dir); len(lines) != num {
+		t.Fatalf("Expected %d committed lines, got %d", num, len(lines))
+	}
+}
+
+// committedLines returns the lines of the committed files and checks that
+// they hold the first values in order.
+func committedLines(t *testing.T, dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	for _, fi := range files {
+		if filepath.Ext(fi.Name()) != committedExt {
+			t.Errorf("Unexpected file left in the sink directory: %s", fi.Name())
+			continue
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := strings.TrimSpace(string(content)); s != "" {
+			lines = append(lines, strings.Split(s, "\n")...)
+		}
+	}
+
+	for i, line := range lines {
+		if line != strconv.Itoa(i) {
+			t.Errorf("Expected line %d to be %d, got %s", i, i, line)
+		}
+	}
+	return lines
+}
+
+func marshalStringData(d Data) ([]byte, error) {
+	return []byte(d.(*stringData).val), nil
+}